@@ -0,0 +1,199 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// queued is an item of output waiting to be delivered by an outputQueue.
+type queued struct {
+	r   responser // New response to pass on Output.Responses()
+	t   Tagged    // Output for the current response
+	end bool      // Close the current response
+
+	spilled int // Length of Line data held in the spill file
+}
+
+// outputQueue holds the output of an execution until it is read, so that
+// the output parser is never blocked by a slow consumer.  Line data is held
+// in memory up to limit bytes, and is otherwise spilled to a temporary file.
+type outputQueue struct {
+	mu     sync.Mutex
+	items  []queued
+	closed bool
+	notify chan struct{} // Signalled when items are added or the queue is closed
+
+	mem   int    // Bytes of Line data held in memory
+	limit int    // Maximum bytes of Line data held in memory
+	dir   string // Directory for the spill file
+
+	f    *os.File // Spill file (created when first needed)
+	woff int64    // Write offset in the spill file
+	roff int64    // Read offset in the spill file
+	err  error    // First error reading the spill file
+}
+
+func newOutputQueue(limit int, dir string) *outputQueue {
+	return &outputQueue{
+		notify: make(chan struct{}, 1),
+		limit:  limit,
+		dir:    dir,
+	}
+}
+
+// push adds the item to the queue.  If the item is a Line which would take
+// the queue over its memory limit then its data is written to the spill file
+// (unless this fails, in which case it is kept in memory).
+func (q *outputQueue) push(it queued) {
+	q.mu.Lock()
+	if l, ok := it.t.(*Line); ok {
+		if n := len(l.Data); q.mem+n > q.limit && q.spill(l.Data) == nil {
+			c := *l
+			c.Data = ""
+			it.t = &c
+			it.spilled = n
+		} else {
+			q.mem += n
+		}
+	}
+	q.items = append(q.items, it)
+	q.mu.Unlock()
+	q.signal()
+}
+
+// spill writes data to the end of the spill file, must be called with q.mu
+// held.
+func (q *outputQueue) spill(data string) error {
+	if q.f == nil {
+		f, err := os.CreateTemp(q.dir, "magma-output-")
+		if err != nil {
+			return err
+		}
+		q.f = f
+	}
+	n, err := q.f.WriteAt([]byte(data), q.woff)
+	if err != nil {
+		// Any partial write is overwritten by the next spill
+		return err
+	}
+	q.woff += int64(n)
+	return nil
+}
+
+// close marks the end of the queue.
+func (q *outputQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *outputQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits for the next item in the queue, returning false if the queue has
+// been closed and all items have been removed.  Returns an error if the Line
+// data of the item could not be read back from the spill file.
+func (q *outputQueue) pop() (queued, bool, error) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			it := q.items[0]
+			q.items[0] = queued{}
+			q.items = q.items[1:]
+			if l, ok := it.t.(*Line); ok && it.spilled == 0 {
+				q.mem -= len(l.Data)
+			}
+			f := q.f
+			q.mu.Unlock()
+
+			if it.spilled > 0 {
+				l := it.t.(*Line)
+				b := make([]byte, it.spilled)
+				n, err := f.ReadAt(b, q.roff)
+				q.roff += int64(it.spilled)
+				if n < len(b) {
+					return it, true, fmt.Errorf("magma/proc: reading spilled output: %v", err)
+				}
+				l.Data = string(b)
+			}
+			return it, true, nil
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return queued{}, false, nil
+		}
+		q.mu.Unlock()
+		<-q.notify
+	}
+}
+
+// remove removes the spill file (if there is one).
+func (q *outputQueue) remove() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.f != nil {
+		q.f.Close()
+		os.Remove(q.f.Name())
+		q.f = nil
+	}
+}
+
+// deliver passes the queued output to the consumer of o, and closes the
+// responses channel once the queue has been emptied.  Lines which cannot be
+// read back from the spill file are dropped (see Output.Err).
+func (o *Output) deliver() {
+	defer close(o.ch)
+	defer o.q.remove()
+
+	var cur responser
+	for {
+		it, ok, err := o.q.pop()
+		if !ok {
+			break
+		}
+		if err != nil {
+			o.q.mu.Lock()
+			if o.q.err == nil {
+				o.q.err = err
+			}
+			o.q.mu.Unlock()
+			continue
+		}
+		switch {
+		case it.r != nil:
+			cur = it.r
+			o.ch <- cur
+		case it.end:
+			cur.close()
+			cur = nil
+		default:
+			cur.send(it.t)
+		}
+	}
+	if cur != nil {
+		cur.close()
+	}
+}
+
+// Err returns the first error reading back output which was spilled to disk
+// (see Process.OutputBuffer), or nil if there was none.  Output which could
+// not be read back is not delivered.  The value is final once all the output
+// has been read.
+func (o Output) Err() error {
+	if o.q == nil {
+		return nil
+	}
+	o.q.mu.Lock()
+	defer o.q.mu.Unlock()
+	return o.q.err
+}
@@ -0,0 +1,132 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magma-proc")
+	checkFatalf(t, "TempDir() error: %v", err)
+	defer os.RemoveAll(dir)
+
+	q := newOutputQueue(10, dir)
+	var expected []string
+	for i := 0; i < 20; i++ {
+		data := strings.Repeat(fmt.Sprint(i%10), i%4)
+		expected = append(expected, data)
+		q.push(queued{t: &Line{tag: TagOutput, Indent: i, Data: data, Truncated: i%3 == 0}})
+	}
+	q.push(queued{end: true})
+	q.close()
+
+	if q.f == nil {
+		t.Fatalf("expected output to be spilled")
+	}
+	if q.mem > 10 {
+		t.Errorf("expected at most 10 bytes in memory, got %d", q.mem)
+	}
+
+	for i, e := range expected {
+		it, ok, err := q.pop()
+		if !ok {
+			t.Fatalf("unexpected end of queue")
+		}
+		checkErrorf(t, "pop() error: %v", err)
+		l, ok := it.t.(*Line)
+		if !ok || l.Data != e || l.Indent != i || l.Truncated != (i%3 == 0) {
+			t.Errorf("expected line %d %q, got %+v", i, e, it.t)
+		}
+	}
+	if it, ok, _ := q.pop(); !ok || !it.end {
+		t.Errorf("expected end of response, got %+v", it)
+	}
+	if _, ok, _ := q.pop(); ok {
+		t.Errorf("expected end of queue")
+	}
+	if q.mem != 0 {
+		t.Errorf("expected no data held in memory, got %d bytes", q.mem)
+	}
+
+	q.remove()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected spill file to be removed, got %v", files)
+	}
+}
+
+func TestOutputQueueSpillReadError(t *testing.T) {
+	q := newOutputQueue(0, t.TempDir())
+	q.push(queued{t: &Line{tag: TagOutput, Data: "spilled"}})
+	q.close()
+	if q.f == nil {
+		t.Fatalf("expected output to be spilled")
+	}
+	defer q.remove()
+
+	// Reading the spilled data back fails
+	q.f.Close()
+	if _, ok, err := q.pop(); !ok || err == nil {
+		t.Errorf("expected error reading spilled output, got: %v", err)
+	}
+}
+
+func TestOutputBuffer(t *testing.T) {
+	const n, line = 1000, "XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX"
+
+	dir, err := ioutil.TempDir("", "magma-proc")
+	checkFatalf(t, "TempDir() error: %v", err)
+	defer os.RemoveAll(dir)
+
+	p := newTestProcess()
+	p.OutputBuffer = 1024
+	p.SpillDir = dir
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	o, err := p.Execute(fmt.Sprintf(`for i in [1..%v+1] do print "%v"; end for;`, n, line))
+	checkFatalf(t, "Execute() error: %v", err)
+
+	// The output is complete without being read
+	select {
+	case <-o.done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for unread output to complete")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected one spill file, got %v", files)
+	}
+
+	// ... and the session can continue
+	res, err := p.Run("1;")
+	checkFatalf(t, "Run() error: %v", err)
+	if text := res.Text(); text != "1" {
+		t.Errorf("expected output %q, got %q", "1", text)
+	}
+
+	count := 0
+	for x := range o.Output() {
+		if l, ok := x.(*Line); !ok || l.Data != line {
+			t.Errorf("expected line %q, got %v", line, x)
+		}
+		count++
+	}
+	if count != n+1 {
+		t.Errorf("expected %d lines, got %d", n+1, count)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected spill file to be removed, got %v", files)
+	}
+
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
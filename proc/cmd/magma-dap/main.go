@@ -0,0 +1,31 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command magma-dap is a Debug Adapter Protocol server for Magma, which
+// communicates with an editor over stdin and stdout (see package dap).
+//
+// The launch request takes the path of the Magma file to load ("program"),
+// and optionally the Magma command ("magma") and extra arguments ("args").
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/dhowden/magma/proc/dap"
+)
+
+var command = flag.String("magma", "", "Magma command used when not given by the launch request")
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("magma-dap: ")
+
+	s := &dap.Server{Command: *command}
+	if err := s.Serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
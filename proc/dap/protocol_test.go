@@ -0,0 +1,46 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMessageFraming(t *testing.T) {
+	var buf bytes.Buffer
+	msgs := []string{`{"seq":1}`, `{"seq":2,"command":"threads"}`}
+	for _, m := range msgs {
+		checkFatalf(t, "writeMessage() error: %v", writeMessage(&buf, []byte(m)))
+	}
+
+	r := bufio.NewReader(&buf)
+	for _, m := range msgs {
+		b, err := readMessage(r)
+		checkFatalf(t, "readMessage() error: %v", err)
+		if string(b) != m {
+			t.Errorf("expected message %q, got %q", m, b)
+		}
+	}
+	if _, err := readMessage(r); err != io.EOF {
+		t.Errorf("expected readMessage() error %v, got %v", io.EOF, err)
+	}
+}
+
+func TestMessageFramingErrors(t *testing.T) {
+	tests := []string{
+		"Content-Type: application/json\r\n\r\n{}",
+		"Content-Length: x\r\n\r\n{}",
+		"Content-Length: 10\r\n\r\n{}",
+	}
+	for _, tt := range tests {
+		if _, err := readMessage(bufio.NewReader(strings.NewReader(tt))); err == nil {
+			t.Errorf("expected readMessage() error for %q", tt)
+		}
+	}
+}
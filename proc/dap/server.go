@@ -0,0 +1,675 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dap implements a Debug Adapter Protocol server for Magma, so that
+// editors can set breakpoints in Magma files, step through code and inspect
+// the parameters of each frame.  The server drives the Magma debugger (see
+// proc.Debugger).
+//
+// Breakpoints can only be applied whilst Magma is stopped in the debugger, or
+// is ready for input (see proc.Debugger.Break).  Breakpoints requested before
+// the program is loaded are applied before loading it.  Breakpoints requested
+// whilst the program is running are applied when it next stops, and until
+// then are reported as unverified.  Breakpoints which are removed remain set in Magma.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dhowden/magma/proc"
+	"github.com/dhowden/magma/proc/parse"
+)
+
+// threadID is the id of the only thread of a Magma session.
+const threadID = 1
+
+// Errors returned to the client.
+var (
+	errNotLaunched = errors.New("magma/dap: program has not been launched")
+	errNotStopped  = errors.New("magma/dap: program is not stopped")
+)
+
+// Server is a Debug Adapter Protocol server for Magma.  A Server handles a
+// single debug session (see Serve).
+type Server struct {
+	// Command (optional) is the Magma command run by a launch request which
+	// does not give one.  If empty, proc.DefaultCommand is used.
+	Command string
+
+	// Env (optional) is the environment of the Magma process (see
+	// proc.Process.Env).
+	Env []string
+
+	wmu  sync.Mutex // Guards w, seq and werr
+	w    io.Writer
+	seq  int
+	werr error // First error writing to w
+
+	p          *proc.Process
+	d          *proc.Debugger
+	program    string
+	configured bool           // Set once the configurationDone request is received
+	wg         sync.WaitGroup // Running program and debugger commands
+
+	amu sync.Mutex // Serialises applyBreakpoints
+
+	mu          sync.Mutex // Guards breakpoints, nextID and frames
+	breakpoints map[string]*bp
+	nextID      int
+	frames      []*parse.Traceback // Frames from the last stackTrace request
+}
+
+// bp is a breakpoint requested by the client.
+type bp struct {
+	breakpoint
+	fn      string // Function name (for function breakpoints)
+	file    string // File (for source breakpoints)
+	applied bool   // Set in the Magma debugger
+}
+
+// Serve runs a debug session, reading requests from r and writing responses
+// and events to w.  Serve returns once the client disconnects, or r is
+// closed, after ending the Magma process.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	s.breakpoints = make(map[string]*bp)
+
+	br := bufio.NewReader(r)
+	for {
+		b, err := readMessage(br)
+		if err != nil {
+			s.shutdown()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(b, &req); err != nil {
+			s.shutdown()
+			return fmt.Errorf("magma/dap: decoding message: %v", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if req.Command == "disconnect" {
+			s.shutdown()
+			s.respond(&req, nil, nil)
+			return s.writeErr()
+		}
+		s.handle(&req)
+		if err := s.writeErr(); err != nil {
+			s.shutdown()
+			return err
+		}
+	}
+}
+
+// handle handles the request req.
+func (s *Server) handle(req *request) {
+	if s.p == nil {
+		switch req.Command {
+		case "initialize", "launch", "setBreakpoints", "setFunctionBreakpoints",
+			"setExceptionBreakpoints", "configurationDone", "threads":
+		default:
+			s.respond(req, nil, errNotLaunched)
+			return
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsEvaluateForHovers":        true,
+		}, nil)
+		s.event("initialized", nil)
+
+	case "launch":
+		err := s.launch(req)
+		s.respond(req, nil, err)
+		if err == nil && s.configured {
+			s.start()
+		}
+
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := decode(req, &args); err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		lines := make([]int, len(args.Breakpoints))
+		for i, b := range args.Breakpoints {
+			lines[i] = b.Line
+		}
+		body := map[string][]breakpoint{"breakpoints": s.setBreakpoints(args.Source.Path, lines)}
+		s.respond(req, body, nil)
+		s.applyBreakpoints()
+
+	case "setFunctionBreakpoints":
+		var args setFunctionBreakpointsArguments
+		if err := decode(req, &args); err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		names := make([]string, len(args.Breakpoints))
+		for i, b := range args.Breakpoints {
+			names[i] = b.Name
+		}
+		body := map[string][]breakpoint{"breakpoints": s.setFunctionBreakpoints(names)}
+		s.respond(req, body, nil)
+		s.applyBreakpoints()
+
+	case "setExceptionBreakpoints":
+		s.respond(req, nil, nil)
+
+	case "configurationDone":
+		// The program is loaded once it has been launched and configured,
+		// whichever comes last
+		s.respond(req, nil, nil)
+		s.configured = true
+		if s.p != nil {
+			s.start()
+		}
+
+	case "threads":
+		s.respond(req, map[string][]thread{"threads": {{ID: threadID, Name: "main"}}}, nil)
+
+	case "stackTrace":
+		frames, err := s.stackTrace()
+		if err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		s.respond(req, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil)
+
+	case "scopes":
+		var args frameArguments
+		if err := decode(req, &args); err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		scopes := []scope{{Name: "Parameters", VariablesReference: args.FrameID}}
+		s.respond(req, map[string][]scope{"scopes": scopes}, nil)
+
+	case "variables":
+		var args variablesArguments
+		if err := decode(req, &args); err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		s.respond(req, map[string][]variable{"variables": s.variables(args.VariablesReference)}, nil)
+
+	case "evaluate":
+		var args evaluateArguments
+		if err := decode(req, &args); err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		result, err := s.evaluate(args.Expression)
+		if err != nil {
+			s.respond(req, nil, err)
+			return
+		}
+		s.respond(req, map[string]interface{}{"result": result, "variablesReference": 0}, nil)
+
+	case "continue":
+		s.resume(req, s.d.Continue, "")
+	case "next":
+		s.resume(req, s.d.Next, "step")
+	case "stepIn":
+		s.resume(req, s.d.Step, "step")
+	case "stepOut":
+		s.resume(req, s.d.Finish, "step")
+
+	default:
+		s.respond(req, nil, fmt.Errorf("magma/dap: unsupported request: %v", req.Command))
+	}
+}
+
+// decode decodes the arguments of the request req into v.
+func decode(req *request, v interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Arguments, v); err != nil {
+		return fmt.Errorf("magma/dap: decoding %v arguments: %v", req.Command, err)
+	}
+	return nil
+}
+
+// send writes the message m to the client, setting its sequence number.  A
+// response whose body cannot be encoded is sent as a failure.  Once writing
+// to the client has failed, no further messages are sent (see writeErr).
+func (s *Server) send(m interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.werr != nil {
+		return
+	}
+
+	s.seq++
+	switch m := m.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	b, err := json.Marshal(m)
+	if r, ok := m.(*response); ok && err != nil {
+		r.Success, r.Body = false, nil
+		r.Message = fmt.Sprintf("magma/dap: encoding %v response: %v", r.Command, err)
+		b, err = json.Marshal(r)
+	}
+	if err != nil {
+		s.werr = fmt.Errorf("magma/dap: encoding message: %v", err)
+		return
+	}
+	if err := writeMessage(s.w, b); err != nil {
+		s.werr = fmt.Errorf("magma/dap: writing message: %v", err)
+	}
+}
+
+// writeErr returns the error which stopped messages being sent to the client,
+// if any.
+func (s *Server) writeErr() error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.werr
+}
+
+// respond sends the response to req, with the given body, or err if the
+// request failed.
+func (s *Server) respond(req *request, body interface{}, err error) {
+	r := &response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		r.Message = err.Error()
+		r.Body = nil
+	}
+	s.send(r)
+}
+
+// event sends the named event with the given body.
+func (s *Server) event(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// output sends an output event with the given category ("stdout" or
+// "stderr").
+func (s *Server) output(category, text string) {
+	s.event("output", map[string]string{"category": category, "output": text})
+}
+
+// outputLine sends the line l as output.
+func (s *Server) outputLine(l *proc.Line) {
+	category := "stdout"
+	if proc.IsError(l) {
+		category = "stderr"
+	}
+	s.output(category, strings.Repeat("    ", l.Indent)+l.Data+"\n")
+}
+
+// launch starts the Magma process for the launch request req.
+func (s *Server) launch(req *request) error {
+	if s.p != nil {
+		return errors.New("magma/dap: program has already been launched")
+	}
+	var args launchArguments
+	if err := decode(req, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return errors.New("magma/dap: launch requires a program")
+	}
+
+	p := &proc.Process{Command: s.Command, Env: s.Env, Args: args.Args}
+	if args.Magma != "" {
+		p.Command = args.Magma
+	}
+	so, err := p.Start()
+	if err != nil {
+		return err
+	}
+	if _, err := p.ReadStartup(so); err != nil {
+		p.Wait()
+		return err
+	}
+
+	// Lines are not wrapped, as output is passed on a line at a time
+	d := p.Debugger()
+	err = p.SetColumns(0)
+	if err == nil {
+		err = d.SetDebugOnError(true)
+	}
+	if err != nil {
+		p.Kill()
+		p.Wait()
+		return err
+	}
+	s.p, s.d, s.program = p, d, args.Program
+	return nil
+}
+
+// start sets the breakpoints requested so far and loads the program,
+// reporting the first time it stops in the debugger.
+func (s *Server) start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.amu.Lock()
+		s.applyPending()
+		s.amu.Unlock()
+		s.load()
+		s.event("terminated", nil)
+	}()
+}
+
+// load loads the program, passing on its output, and reports the first time
+// it stops in the debugger.
+func (s *Server) load() {
+	ch, stop := s.p.Transitions(16)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for t := range ch {
+			switch t.To {
+			case proc.StateDebugging:
+				stop()
+				s.stopped("")
+			case proc.StateReady, proc.StateExited:
+				stop()
+			}
+		}
+	}()
+
+	o, err := s.p.Execute(fmt.Sprintf("load %v;", proc.Quote(s.program)))
+	if err != nil {
+		stop()
+		s.output("stderr", err.Error()+"\n")
+		return
+	}
+	for x := range o.Output() {
+		switch x := x.(type) {
+		case *proc.ReadRequest:
+			x.Output <- ""
+		case *proc.Line:
+			s.outputLine(x)
+		}
+	}
+}
+
+// resume responds to the request req and then runs f, which continues the
+// program in the debugger, reporting the reason if the program stops again
+// (see stopped).
+func (s *Server) resume(req *request, f func() (*proc.Result, error), reason string) {
+	if !s.d.Stopped() {
+		s.respond(req, nil, errNotStopped)
+		return
+	}
+	s.respond(req, map[string]bool{"allThreadsContinued": true}, nil)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		res, err := f()
+		if err != nil {
+			s.output("stderr", err.Error()+"\n")
+			return
+		}
+		for _, st := range res.Statements {
+			for _, x := range st.Output {
+				if l, ok := x.(*proc.Line); ok {
+					s.outputLine(l)
+				}
+			}
+		}
+		if s.d.Stopped() {
+			s.stopped(reason)
+		}
+	}()
+}
+
+// stopped applies any pending breakpoints and reports that the program has
+// stopped for the given reason.  If reason is empty then it is "breakpoint"
+// if the program stopped at a breakpoint, and otherwise "exception" (as it
+// stopped on an error).
+func (s *Server) stopped(reason string) {
+	s.mu.Lock()
+	s.frames = nil
+	s.mu.Unlock()
+
+	s.applyBreakpoints()
+	if reason == "" {
+		reason = "exception"
+		if s.atBreakpoint() {
+			reason = "breakpoint"
+		}
+	}
+	s.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	})
+}
+
+// setBreakpoints replaces the breakpoints in file with those on the given
+// lines.
+func (s *Server) setBreakpoints(file string, lines []int) []breakpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := make(map[string]bool)
+	out := make([]breakpoint, len(lines))
+	for i, l := range lines {
+		key := fmt.Sprintf("%v:%d", file, l)
+		b, ok := s.breakpoints[key]
+		if !ok {
+			s.nextID++
+			b = &bp{file: file, breakpoint: breakpoint{
+				ID:     s.nextID,
+				Line:   l,
+				Source: &source{Name: filepath.Base(file), Path: file},
+			}}
+			s.breakpoints[key] = b
+		}
+		keep[key] = true
+		out[i] = b.breakpoint
+	}
+	for key, b := range s.breakpoints {
+		if b.file == file && !keep[key] {
+			delete(s.breakpoints, key)
+		}
+	}
+	return out
+}
+
+// setFunctionBreakpoints replaces the function breakpoints with those on the
+// given functions.
+func (s *Server) setFunctionBreakpoints(names []string) []breakpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := make(map[string]bool)
+	out := make([]breakpoint, len(names))
+	for i, n := range names {
+		b, ok := s.breakpoints[n]
+		if !ok {
+			s.nextID++
+			b = &bp{fn: n, breakpoint: breakpoint{ID: s.nextID}}
+			s.breakpoints[n] = b
+		}
+		keep[n] = true
+		out[i] = b.breakpoint
+	}
+	for key, b := range s.breakpoints {
+		if b.fn != "" && !keep[key] {
+			delete(s.breakpoints, key)
+		}
+	}
+	return out
+}
+
+// atBreakpoint returns true if the current frame of the stopped program is
+// at one of the breakpoints.
+func (s *Server) atBreakpoint() bool {
+	frames, err := parse.Frames(s.d)
+	if err != nil || len(frames) == 0 {
+		return false
+	}
+	f := frames[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.breakpoints {
+		switch {
+		case !b.applied:
+		case b.fn != "" && b.fn == f.Name:
+			return true
+		case b.file != "" && b.file == f.Location.File && b.Line == f.Location.Row:
+			return true
+		}
+	}
+	return false
+}
+
+// pending returns the breakpoints which have not been set in Magma, in the
+// order they were requested.
+func (s *Server) pending() []*bp {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []*bp
+	for _, b := range s.breakpoints {
+		if !b.applied {
+			pending = append(pending, b)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+	return pending
+}
+
+// applyBreakpoints sets any pending breakpoints in the Magma debugger (if the
+// program is stopped), reporting those which have been verified.
+func (s *Server) applyBreakpoints() {
+	s.amu.Lock()
+	defer s.amu.Unlock()
+	if s.d == nil || !s.d.Stopped() {
+		return
+	}
+	s.applyPending()
+}
+
+// applyPending sets the pending breakpoints in Magma, which must be stopped in
+// the debugger or ready for input.  Must be called with s.amu held.
+func (s *Server) applyPending() {
+	for _, b := range s.pending() {
+		var err error
+		if b.fn != "" {
+			err = s.d.Break(b.fn)
+		} else {
+			err = s.d.BreakAt(b.file, b.Line)
+		}
+		if err != nil {
+			s.output("stderr", err.Error()+"\n")
+			continue
+		}
+
+		s.mu.Lock()
+		b.applied, b.Verified = true, true
+		v := b.breakpoint
+		s.mu.Unlock()
+		s.event("breakpoint", map[string]interface{}{"reason": "changed", "breakpoint": v})
+	}
+}
+
+// stackTrace returns the frames of the stopped program.
+func (s *Server) stackTrace() ([]stackFrame, error) {
+	if !s.d.Stopped() {
+		return nil, errNotStopped
+	}
+	frames, err := parse.Frames(s.d)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.frames = frames
+	s.mu.Unlock()
+
+	out := make([]stackFrame, len(frames))
+	for i, f := range frames {
+		out[i] = stackFrame{ID: i + 1, Name: f.Name, Line: f.Location.Row, Column: 1}
+		// Files such as <main> and <eval> are not on disk
+		if file := f.Location.File; file != "" && !strings.HasPrefix(file, "<") {
+			out[i].Source = &source{Name: filepath.Base(file), Path: file}
+		}
+	}
+	return out, nil
+}
+
+// variables returns the parameters of the frame with the given reference
+// (see stackTrace).
+func (s *Server) variables(ref int) []variable {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []variable{}
+	if ref < 1 || ref > len(s.frames) {
+		return out
+	}
+	for _, pv := range s.frames[ref-1].Params {
+		out = append(out, variable{Name: pv.Name, Value: pv.Value})
+	}
+	return out
+}
+
+// evaluate evaluates the expression expr in the current frame.
+func (s *Server) evaluate(expr string) (string, error) {
+	if !s.d.Stopped() {
+		return "", errNotStopped
+	}
+	res, err := s.d.Eval(expr)
+	if err != nil {
+		return "", err
+	}
+	if err := res.Err(); err != nil {
+		return "", err
+	}
+	return res.Text(), nil
+}
+
+// shutdown ends the Magma process (if any), and waits for the program and
+// debugger commands to finish.
+func (s *Server) shutdown() {
+	if s.p == nil {
+		return
+	}
+	if st, _ := s.p.State(); st == proc.StateReady {
+		if q, err := s.p.Quit(); err == nil {
+			<-q
+		} else {
+			s.p.Kill()
+		}
+	} else {
+		s.p.Kill()
+	}
+	s.p.Wait()
+	s.wg.Wait()
+}
@@ -0,0 +1,278 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhowden/magma/proc/magmatest"
+)
+
+type errorfer interface {
+	Errorf(string, ...interface{})
+	Fatalf(string, ...interface{})
+}
+
+func checkErrorf(t errorfer, fmt string, err error) {
+	if err != nil {
+		t.Errorf(fmt, err)
+	}
+}
+
+func checkFatalf(t errorfer, fmt string, err error) {
+	if err != nil {
+		t.Fatalf(fmt, err)
+	}
+}
+
+// fakeName is the name of the fake Magma used by these tests.
+const fakeName = "dap"
+
+// program is the file loaded by the tests.
+const program = "/tmp/f.m"
+
+// debugCommand handles the debugger commands given by the tests.
+func debugCommand(s *magmatest.Stmt, cmd string) bool {
+	switch {
+	case cmd == "continue":
+		return false
+	case strings.HasPrefix(cmd, "break "):
+		s.Printf("Breakpoint set at %v\n", strings.TrimPrefix(cmd, "break "))
+	case cmd == "next":
+		s.Print("x := x + 1;")
+	case cmd == "bt":
+		s.Traceback("#0 *f(\n    x: 3\n) at " + program + ":2\n#1 <main>(\n) at <main>:1")
+	case cmd == "print x":
+		s.Print("3")
+	default:
+		s.UserError("Identifier has not been declared or assigned")
+	}
+	return true
+}
+
+func TestMain(m *testing.M) {
+	f := &magmatest.Fake{}
+	f.Handle(`load "`+program+`";`, func(s *magmatest.Stmt) {
+		s.Print("loading")
+		s.Debug(debugCommand)
+		s.Print("done")
+	})
+	magmatest.Register(fakeName, f)
+	magmatest.Main()
+	os.Exit(m.Run())
+}
+
+// client is a Debug Adapter Protocol client for a Server.
+type client struct {
+	t      *testing.T
+	w      io.Writer
+	seq    int
+	msgs   chan map[string]interface{}
+	done   chan error
+	output []string // Output events received
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{
+		t:    t,
+		w:    inW,
+		msgs: make(chan map[string]interface{}, 64),
+		done: make(chan error, 1),
+	}
+
+	cmd, env := magmatest.Command(fakeName)
+	s := &Server{Command: cmd, Env: env}
+	go func() {
+		c.done <- s.Serve(inR, outW)
+		outW.Close()
+	}()
+
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			b, err := readMessage(r)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			var m map[string]interface{}
+			checkErrorf(t, "Unmarshal() error: %v", json.Unmarshal(b, &m))
+			c.msgs <- m
+		}
+	}()
+	return c
+}
+
+// request sends a request, and returns the body of its response (failing the
+// test unless the response has the given success value).
+func (c *client) request(command string, args interface{}, success bool) map[string]interface{} {
+	c.seq++
+	b, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	checkFatalf(c.t, "Marshal() error: %v", err)
+	checkFatalf(c.t, "writeMessage() error: %v", writeMessage(c.w, b))
+
+	m := c.expect("response", command)
+	if m["request_seq"] != float64(c.seq) {
+		c.t.Errorf("expected request_seq %v, got %v", c.seq, m["request_seq"])
+	}
+	if m["success"] != success {
+		c.t.Errorf("expected %v response success %v, got %v (%v)", command, success, m["success"], m["message"])
+	}
+	body, _ := m["body"].(map[string]interface{})
+	return body
+}
+
+// expect reads messages until one of the given type with the given command
+// or event name, recording any output events.
+func (c *client) expect(typ, name string) map[string]interface{} {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("expected %v %v, but messages ended", typ, name)
+			}
+			if m["type"] == "event" && m["event"] == "output" {
+				c.output = append(c.output, m["body"].(map[string]interface{})["output"].(string))
+			}
+			if m["type"] == typ && (m["command"] == name || m["event"] == name) {
+				return m
+			}
+		case <-timeout:
+			c.t.Fatalf("timed out waiting for %v %v", typ, name)
+		}
+	}
+}
+
+// expectEvent reads messages until the named event, and returns its body.
+func (c *client) expectEvent(name string) map[string]interface{} {
+	body, _ := c.expect("event", name)["body"].(map[string]interface{})
+	return body
+}
+
+func TestServer(t *testing.T) {
+	c := newClient(t)
+
+	c.request("stackTrace", nil, false)
+	c.request("initialize", map[string]string{"adapterID": "magma"}, true)
+	c.expectEvent("initialized")
+
+	body := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": program},
+		"breakpoints": []map[string]int{{"line": 2}},
+	}, true)
+	bps := body["breakpoints"].([]interface{})
+	if len(bps) != 1 || bps[0].(map[string]interface{})["verified"] != false {
+		t.Errorf("expected one unverified breakpoint, got %v", bps)
+	}
+
+	c.request("launch", map[string]string{}, false)
+	c.request("launch", map[string]string{"program": program}, true)
+	c.request("configurationDone", nil, true)
+
+	bp := c.expectEvent("breakpoint")["breakpoint"].(map[string]interface{})
+	if bp["verified"] != true || bp["line"] != float64(2) {
+		t.Errorf("expected verified breakpoint on line 2, got %v", bp)
+	}
+	if reason := c.expectEvent("stopped")["reason"]; reason != "breakpoint" {
+		t.Errorf("expected stopped reason %q, got %q", "breakpoint", reason)
+	}
+
+	c.request("threads", nil, true)
+	body = c.request("stackTrace", map[string]int{"threadId": 1}, true)
+	frames := body["stackFrames"].([]interface{})
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %v", frames)
+	}
+	f0 := frames[0].(map[string]interface{})
+	if f0["name"] != "f" || f0["line"] != float64(2) || f0["source"].(map[string]interface{})["path"] != program {
+		t.Errorf("unexpected first frame: %v", f0)
+	}
+	if f1 := frames[1].(map[string]interface{}); f1["source"] != nil {
+		t.Errorf("expected no source for <main> frame, got %v", f1["source"])
+	}
+
+	body = c.request("scopes", map[string]int{"frameId": 1}, true)
+	ref := body["scopes"].([]interface{})[0].(map[string]interface{})["variablesReference"]
+	body = c.request("variables", map[string]interface{}{"variablesReference": ref}, true)
+	expected := []interface{}{map[string]interface{}{"name": "x", "value": "3", "variablesReference": float64(0)}}
+	if !reflect.DeepEqual(body["variables"], expected) {
+		t.Errorf("expected variables %v, got %v", expected, body["variables"])
+	}
+
+	body = c.request("evaluate", map[string]string{"expression": "x"}, true)
+	if body["result"] != "3" {
+		t.Errorf("expected evaluate result %q, got %v", "3", body["result"])
+	}
+	c.request("evaluate", map[string]string{"expression": "y"}, false)
+
+	c.request("next", map[string]int{"threadId": 1}, true)
+	if reason := c.expectEvent("stopped")["reason"]; reason != "step" {
+		t.Errorf("expected stopped reason %q, got %q", "step", reason)
+	}
+
+	c.request("continue", map[string]int{"threadId": 1}, true)
+	c.expectEvent("terminated")
+	c.request("next", map[string]int{"threadId": 1}, false)
+
+	c.request("disconnect", nil, true)
+	select {
+	case err := <-c.done:
+		checkErrorf(t, "Serve() error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve() did not return")
+	}
+
+	expectedOutput := []string{"loading\n", "x := x + 1;\n", "done\n"}
+	if !reflect.DeepEqual(c.output, expectedOutput) {
+		t.Errorf("expected output %q, got %q", expectedOutput, c.output)
+	}
+}
+
+func TestServerStopOnError(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]string{"adapterID": "magma"}, true)
+	c.expectEvent("initialized")
+	c.request("launch", map[string]string{"program": program}, true)
+	c.request("configurationDone", nil, true)
+
+	if reason := c.expectEvent("stopped")["reason"]; reason != "exception" {
+		t.Errorf("expected stopped reason %q, got %q", "exception", reason)
+	}
+	c.request("continue", map[string]int{"threadId": 1}, true)
+	c.expectEvent("terminated")
+	c.request("disconnect", nil, true)
+	checkErrorf(t, "Serve() error: %v", <-c.done)
+}
+
+// errWriter is an io.Writer which always fails.
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestServeWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	checkFatalf(t, "writeMessage() error: %v", writeMessage(&buf, []byte(`{"seq":1,"type":"request","command":"initialize"}`)))
+
+	s := &Server{}
+	if err := s.Serve(&buf, errWriter{}); err == nil {
+		t.Errorf("expected Serve() error when writing fails")
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Commands understood by the Magma debugger.
+const (
+	debugBreak     = "break"
+	debugStep      = "step"
+	debugNext      = "next"
+	debugFinish    = "finish"
+	debugContinue  = "continue"
+	debugBacktrace = "bt"
+	debugPrint     = "print"
+)
+
+// setBreakPoint is the Magma intrinsic which sets a breakpoint outside of the
+// debugger.
+const setBreakPoint = "SetBreakPoint"
+
+// ErrNotStopped is returned by the Debugger methods which run debugger
+// commands when the process is not stopped in the debugger.
+var ErrNotStopped = errors.New("magma/proc: process is not stopped in the debugger")
+
+// Debugger controls the Magma debugger for a Process.  Magma stops in the
+// debugger (signalled by a DRDY tag) at breakpoints, and on errors once
+// SetDebugOnError has been called.  Whilst stopped, the statement being run
+// is incomplete: its Output resumes once the debugger is left.
+//
+// Output produced whilst running a debugger command (for instance by
+// stepping) is part of the output of the command, which is complete when the
+// debugger is next ready or the statement being debugged completes.
+type Debugger struct {
+	p *Process
+}
+
+// Debugger returns the Debugger for the process.
+func (p *Process) Debugger() *Debugger {
+	return &Debugger{p: p}
+}
+
+// Stopped returns true if the process is stopped in the debugger.
+func (d *Debugger) Stopped() bool {
+	s, _ := d.p.State()
+	return s == StateDebugging
+}
+
+// WaitStopped waits until the process is stopped in the debugger.  An error
+// is returned if the context is done, or the process exits, first.
+func (d *Debugger) WaitStopped(ctx context.Context) error {
+	tch, stop := d.p.Transitions(16)
+	defer stop()
+	if d.Stopped() {
+		return nil
+	}
+	for {
+		select {
+		case t, ok := <-tch:
+			if !ok {
+				return errors.New("magma/proc: process exited before stopping in the debugger")
+			}
+			if t.To == StateDebugging {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// SetDebugOnError sets whether Magma enters the debugger when an error occurs.
+// The process must be ready for input (see Execute).
+func (d *Debugger) SetDebugOnError(on bool) error {
+	res, err := d.p.Run(fmt.Sprintf("SetDebugOnError(%v);", on))
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// Command passes the command cmd to the debugger once the process is stopped
+// in the debugger, and returns its output.  The command is checked for
+// invalid characters as with Execute.  If the context is done before the
+// debugger is ready then the command is not sent.
+func (d *Debugger) Command(ctx context.Context, cmd string) (*Output, error) {
+	p := d.p
+	err := p.checkRunning()
+	if err != nil {
+		return nil, err
+	}
+	cmd, err = sanitizeInput(cmd, p.InputPolicy, false, p.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	sent, err := p.Encoding.encode(cmd, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait until the debugger is ready for a command
+	var dch chan *Output
+	select {
+	case c, ok := <-p.debugReady:
+		if !ok {
+			return nil, errors.New("magma/proc: debugger command given after process has completed")
+		}
+		dch = c
+	case <-p.exited:
+		return nil, errors.New("magma/proc: debugger command given after process has exited")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	o := p.newOutput(cmd, sent)
+	dch <- o
+
+	w := <-p.writer
+	defer func() {
+		p.writer <- w
+	}()
+	_, err = w.Write(append([]byte(sent), runCommandChar))
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// run passes the command cmd to the debugger, and waits for its output.
+// ErrNotStopped is returned if the process is not stopped in the debugger.
+func (d *Debugger) run(cmd string) (*Result, error) {
+	if !d.Stopped() {
+		return nil, ErrNotStopped
+	}
+	o, err := d.Command(context.Background(), cmd)
+	if err != nil {
+		return nil, err
+	}
+	res := collectResult(o, d.p.MaxOutput)
+	if res.Outcome == OutcomeExited {
+		return res, ErrExited
+	}
+	return res, o.Err()
+}
+
+// breakpoint sets a breakpoint using the debugger command cmd if the process
+// is stopped in the debugger, or otherwise by running the statement stmt once
+// the process is ready for input.  ErrNotStopped is returned if the process
+// is running a statement.
+func (d *Debugger) breakpoint(cmd, stmt string) error {
+	var res *Result
+	var err error
+	switch s, _ := d.p.State(); s {
+	case StateDebugging:
+		res, err = d.run(cmd)
+	case StateStarting, StateReady:
+		res, err = d.p.Run(stmt)
+	default:
+		return ErrNotStopped
+	}
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// Break sets a breakpoint on the function (or intrinsic) fn.  Breakpoints can
+// be set whilst the process is stopped in the debugger, or is ready for input.
+func (d *Debugger) Break(fn string) error {
+	return d.breakpoint(fmt.Sprintf("%v %v", debugBreak, fn),
+		fmt.Sprintf("%v(%v);", setBreakPoint, Quote(fn)))
+}
+
+// BreakAt sets a breakpoint on the given line of the file (see Break).
+func (d *Debugger) BreakAt(file string, line int) error {
+	return d.breakpoint(fmt.Sprintf("%v %v:%d", debugBreak, file, line),
+		fmt.Sprintf("%v(%v, %d);", setBreakPoint, Quote(file), line))
+}
+
+// Step runs to the next statement, stepping into function calls.
+func (d *Debugger) Step() (*Result, error) { return d.run(debugStep) }
+
+// Next runs to the next statement in the current frame.
+func (d *Debugger) Next() (*Result, error) { return d.run(debugNext) }
+
+// Finish runs until the current frame returns.
+func (d *Debugger) Finish() (*Result, error) { return d.run(debugFinish) }
+
+// Continue leaves the debugger, and runs until the next breakpoint or the
+// statement being debugged completes.
+func (d *Debugger) Continue() (*Result, error) { return d.run(debugContinue) }
+
+// Backtrace returns the traceback of the current frames (see
+// ParseTraceback).
+func (d *Debugger) Backtrace() (*Result, error) { return d.run(debugBacktrace) }
+
+// Eval evaluates the expression expr in the current frame.
+func (d *Debugger) Eval(expr string) (*Result, error) {
+	return d.run(fmt.Sprintf("%v %v", debugPrint, expr))
+}
@@ -0,0 +1,106 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDebugger(t *testing.T) {
+	p := newFakeProcess()
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	d := p.Debugger()
+	if d.Stopped() {
+		t.Errorf("expected Stopped() false before running")
+	}
+	checkErrorf(t, "Break() error before running: %v", d.Break("g"))
+	checkErrorf(t, "BreakAt() error before running: %v", d.BreakAt("/tmp/f.m", 3))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = d.Command(ctx, "bt")
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected Command() error %v when not stopped, got %v", context.DeadlineExceeded, err)
+	}
+
+	done := make(chan *Result, 1)
+	go func() {
+		res, err := p.Run("f(3);")
+		checkErrorf(t, "Run() error: %v", err)
+		done <- res
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	checkFatalf(t, "WaitStopped() error: %v", d.WaitStopped(ctx))
+	cancel()
+
+	checkErrorf(t, "Break() error: %v", d.Break("g"))
+	checkErrorf(t, "BreakAt() error: %v", d.BreakAt("/tmp/f.m", 3))
+	if !d.Stopped() {
+		t.Errorf("expected Stopped() true")
+	}
+
+	res, err := d.Eval("x")
+	checkFatalf(t, "Eval() error: %v", err)
+	if res.Text() != "3" {
+		t.Errorf("expected Eval() output %q, got %q", "3", res.Text())
+	}
+
+	res, err = d.Eval("y")
+	checkFatalf(t, "Eval() error: %v", err)
+	var me *MagmaError
+	if !errors.As(res.Err(), &me) {
+		t.Errorf("expected *MagmaError evaluating undefined identifier, got %v", res.Err())
+	}
+
+	res, err = d.Step()
+	checkFatalf(t, "Step() error: %v", err)
+	if res.Text() != "x := x + 1;" {
+		t.Errorf("expected Step() output %q, got %q", "x := x + 1;", res.Text())
+	}
+
+	res, err = d.Backtrace()
+	checkFatalf(t, "Backtrace() error: %v", err)
+	if n := len(res.Statements[0].Lines[TagTraceback]); n != 3 {
+		t.Errorf("expected 3 lines of traceback, got %d", n)
+	}
+
+	res, err = d.Continue()
+	checkFatalf(t, "Continue() error: %v", err)
+	if res.Text() != "done" {
+		t.Errorf("expected Continue() output %q, got %q", "done", res.Text())
+	}
+	if d.Stopped() {
+		t.Errorf("expected Stopped() false after Continue()")
+	}
+	if _, err := d.Step(); err != ErrNotStopped {
+		t.Errorf("expected Step() error %v after Continue(), got %v", ErrNotStopped, err)
+	}
+
+	select {
+	case res := <-done:
+		if res.Text() != "start" {
+			t.Errorf("expected Run() output %q, got %q", "start", res.Text())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for statement to complete")
+	}
+	if s, _ := p.State(); s != StateReady {
+		t.Errorf("expected State() %v, got %v", StateReady, s)
+	}
+
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+
+	if _, err := d.Command(context.Background(), "bt"); err == nil {
+		t.Errorf("expected Command() error after process has exited")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// Encoding is the character encoding used for Magma input and output (see
+// Process.Encoding).  Output is always decoded to valid UTF-8.
+type Encoding int
+
+// Character encodings.
+const (
+	// EncodingAuto passes input through as UTF-8, and decodes output by
+	// keeping valid UTF-8 sequences and decoding any other bytes as Latin-1.
+	EncodingAuto Encoding = iota
+
+	// EncodingLatin1 encodes input and decodes output as ISO 8859-1.
+	EncodingLatin1
+
+	// EncodingUTF8 passes input through as UTF-8, and replaces invalid UTF-8
+	// sequences in output with U+FFFD.
+	EncodingUTF8
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case EncodingAuto:
+		return "auto"
+	case EncodingLatin1:
+		return "latin-1"
+	case EncodingUTF8:
+		return "utf-8"
+	}
+	return "unknown"
+}
+
+// decode returns the output b as valid UTF-8.
+func (e Encoding) decode(b []byte) []byte {
+	switch e {
+	case EncodingLatin1:
+		return latin1ToUTF8(b, false)
+	case EncodingUTF8:
+		return bytes.ToValidUTF8(b, []byte(string(utf8.RuneError)))
+	}
+	return latin1ToUTF8(b, true)
+}
+
+// latin1ToUTF8 decodes the Latin-1 bytes in b, leaving valid UTF-8 sequences
+// unchanged if keepUTF8 is set.
+func latin1ToUTF8(b []byte, keepUTF8 bool) []byte {
+	i := 0
+	for i < len(b) && b[i] < utf8.RuneSelf {
+		i++
+	}
+	if i == len(b) {
+		return b
+	}
+
+	out := make([]byte, i, len(b)+len(b)/2)
+	copy(out, b[:i])
+	for i < len(b) {
+		if b[i] < utf8.RuneSelf {
+			out = append(out, b[i])
+			i++
+			continue
+		}
+		if keepUTF8 {
+			if r, n := utf8.DecodeRune(b[i:]); r != utf8.RuneError || n > 1 {
+				out = append(out, b[i:i+n]...)
+				i += n
+				continue
+			}
+		}
+		out = utf8.AppendRune(out, rune(b[i]))
+		i++
+	}
+	return out
+}
+
+// encodeChar returns the first character of s, its encoding for Magma (or
+// "" if it cannot be encoded, see encode), and its length in s.  Bytes which
+// are not part of a valid UTF-8 sequence are passed through.
+func (e Encoding) encodeChar(s string) (rune, string, int) {
+	r, n := utf8.DecodeRuneInString(s)
+	if e == EncodingLatin1 && n > 1 {
+		if r < 0x100 {
+			return r, string([]byte{byte(r)}), n
+		}
+		return r, "", n
+	}
+	return r, s[:n], n
+}
+
+// encode returns the input s encoded for Magma.  Characters which cannot be
+// encoded are replaced by '?' if replace is set, and otherwise an
+// *InvalidInputError is returned.
+func (e Encoding) encode(s string, replace bool) (string, error) {
+	if e != EncodingLatin1 {
+		return s, nil
+	}
+
+	b := make([]byte, 0, len(s))
+	for i, r := range s {
+		switch {
+		case r < 0x100:
+			b = append(b, byte(r))
+		case replace:
+			b = append(b, '?')
+		default:
+			return "", &InvalidInputError{Offset: i, Char: int(r)}
+		}
+	}
+	return string(b), nil
+}
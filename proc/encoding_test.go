@@ -0,0 +1,87 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncodingDecode(t *testing.T) {
+	tests := []struct {
+		enc     Encoding
+		in, out string
+	}{
+		{EncodingAuto, "abc", "abc"},
+		{EncodingAuto, "caf\xe9", "café"},
+		{EncodingAuto, "café", "café"},
+		{EncodingAuto, "caf\xe9 café", "café café"},
+		{EncodingLatin1, "caf\xe9", "café"},
+		{EncodingLatin1, "café", "cafÃ©"},
+		{EncodingUTF8, "café", "café"},
+		{EncodingUTF8, "caf\xe9", "caf�"},
+	}
+
+	for _, tt := range tests {
+		if out := string(tt.enc.decode([]byte(tt.in))); out != tt.out {
+			t.Errorf("%v decode(%q): expected %q, got %q", tt.enc, tt.in, tt.out, out)
+		}
+	}
+}
+
+func TestEncodingEncode(t *testing.T) {
+	tests := []struct {
+		enc     Encoding
+		in, out string
+		replace bool
+	}{
+		{EncodingAuto, "café €", "café €", false},
+		{EncodingUTF8, "café €", "café €", false},
+		{EncodingLatin1, "café", "caf\xe9", false},
+		{EncodingLatin1, "café €", "caf\xe9 ?", true},
+	}
+
+	for _, tt := range tests {
+		out, err := tt.enc.encode(tt.in, tt.replace)
+		checkErrorf(t, "encode() error: %v", err)
+		if out != tt.out {
+			t.Errorf("%v encode(%q): expected %q, got %q", tt.enc, tt.in, tt.out, out)
+		}
+	}
+
+	var e *InvalidInputError
+	if _, err := EncodingLatin1.encode("café €", false); !errors.As(err, &e) || e.Offset != 6 || e.Char != '€' {
+		t.Errorf("expected *InvalidInputError at offset 6, got %v", err)
+	}
+}
+
+func TestProcessEncodingLatin1(t *testing.T) {
+	const cmd = `x := "Hélène"; print x;`
+
+	p := newTestProcess()
+	p.Encoding = EncodingLatin1
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	res, err := p.Run(cmd)
+	checkFatalf(t, "Run() error: %v", err)
+	if text := res.Text(); text != "Hélène" {
+		t.Errorf("expected output %q, got %q", "Hélène", text)
+	}
+	if n := len(res.Statements); n != 2 || res.Statements[1].Source != "print x;" {
+		t.Errorf("expected 2 statements ending with %q, got %+v", "print x;", res.Statements)
+	} else if src := res.Statements[0].Source; src != `x := "Hélène";` {
+		t.Errorf("expected statement source %q, got %q", `x := "Hélène";`, src)
+	}
+
+	var e *InvalidInputError
+	if _, err := p.Execute(`print "€";`); !errors.As(err, &e) {
+		t.Errorf("expected *InvalidInputError for unencodable input, got %v", err)
+	}
+
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
@@ -0,0 +1,178 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dhowden/magma/proc/internal/lineparse"
+)
+
+// ErrorPosition represents the position of an error as reported by the magma EPO
+// (TagErrorPosition) tag.
+type ErrorPosition struct {
+	File           string         // The file (if any)
+	Eval           bool           // Eval == true iff File == ""
+	Row, Column    int            // The line and column of the error
+	SourceFragment string         // A string containing the problem
+	LocatedIn      *ErrorPosition // Further location information
+}
+
+// ParseErrorPosition parses the error position chain from the data of EPO
+// lines.  Returns nil (and no error) if the lines do not start with an error
+// position.
+func ParseErrorPosition(lines []string) (*ErrorPosition, error) {
+	p := &errorPositionParser{Consumer: lineparse.NewSliceConsumer(lines)}
+	state := parseTopLevel
+	for state != nil {
+		state = state(p)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.current, nil
+}
+
+type errorPositionParserStateFn func(*errorPositionParser) errorPositionParserStateFn
+
+// errorPositionParser is the container associated with the error position parser
+type errorPositionParser struct {
+	*lineparse.Consumer
+	err        error          // Parse error (if any)
+	current    *ErrorPosition // Current ErrorPosition object (in construction)
+	currentSub *ErrorPosition // Current `LocatedIn` ErrorPosition object that is being built
+}
+
+func parseTopLevel(p *errorPositionParser) errorPositionParserStateFn {
+	if p.FetchNextLine() {
+		if line := strings.TrimPrefix(p.Line, "In eval expression, "); len(p.Line) > len(line) {
+			// `In eval expression, line <x>, column <y>:`
+			row, col, err := extractRowColumnFromString(strings.TrimSuffix(line, ":"))
+			if err != nil {
+				p.err = err
+				return nil
+			}
+
+			p.current = &ErrorPosition{
+				Eval:   true,
+				Row:    row,
+				Column: col,
+			}
+		} else if line := strings.TrimPrefix(p.Line, "In file "); len(p.Line) > len(line) {
+			// `In file "<path-to-file>", line <x>, column <y>:`
+			file, row, col, err := extractFileRowColumn(strings.TrimSuffix(line, ":"))
+			if err != nil {
+				p.err = err
+				return nil
+			}
+
+			p.current = &ErrorPosition{
+				File:   file,
+				Row:    row,
+				Column: col,
+			}
+		} else {
+			return nil
+		}
+		p.ConsumeLine()
+		return parseSourceFragment
+	}
+	return nil
+}
+
+func parseSourceFragment(p *errorPositionParser) errorPositionParserStateFn {
+	if p.FetchNextLine() {
+		if line := strings.TrimPrefix(p.Line, ">> "); len(p.Line) > len(line) {
+			if p.currentSub != nil {
+				p.currentSub.SourceFragment = line
+			} else {
+				p.current.SourceFragment = line
+			}
+			p.ConsumeLine()
+			return parseLocatedInExpression
+		}
+	}
+	p.err = fmt.Errorf("expected source fragment line, got %v", p.Line)
+	return nil
+}
+
+func parseLocatedInExpression(p *errorPositionParser) errorPositionParserStateFn {
+	if p.FetchNextLine() {
+		if line := strings.TrimPrefix(p.Line, "Located in"); len(p.Line) > len(line) {
+			var s *ErrorPosition
+			if line2 := strings.TrimPrefix(line, " enclosing eval expression, at "); len(line2) < len(line) {
+				// Located in enclosing eval expression, at line x, column y:
+				row, col, err := extractRowColumnFromString(strings.TrimSuffix(line2, ":"))
+				if err != nil {
+					p.err = err
+					return nil
+				}
+
+				s = &ErrorPosition{
+					Eval:   true,
+					Row:    row,
+					Column: col,
+				}
+			} else if line2 := strings.TrimPrefix(line, " file "); len(line) > len(line2) {
+				// Located in file "<path-to-file>", at line <x>, column <y>:
+				file, row, col, err := extractFileRowColumn(strings.TrimSuffix(line2, ":"))
+				if err != nil {
+					p.err = err
+					return nil
+				}
+
+				s = &ErrorPosition{
+					File:   file,
+					Row:    row,
+					Column: col,
+				}
+			} else if line == ":" {
+				// Located in:
+				s = &ErrorPosition{}
+			} else {
+				p.err = errors.New("`Located in` line with unrecognised suffix")
+				return nil
+			}
+
+			if p.current.LocatedIn == nil {
+				p.current.LocatedIn = s
+			} else {
+				p.currentSub.LocatedIn = s
+			}
+			p.currentSub = s
+			p.ConsumeLine()
+			return parseSourceFragment
+		}
+	}
+	return nil
+}
+
+// Extract file, row, column, from a string with format:
+// `<file>, [at] line <row>, column <column>:`
+func extractFileRowColumn(input string) (file string, row, col int, err error) {
+	commaSplit := strings.FieldsFunc(input, lineparse.MatchCommaRune)
+	if len(commaSplit) < 3 {
+		err = errors.New("expected at least 3 (file, line, column) in comma split")
+		return
+	}
+	file = commaSplit[0]
+	if len(file) < 2 || file[0] != '"' || file[len(file)-1] != '"' {
+		err = fmt.Errorf("expected quoted file name, got %v", file)
+		return
+	}
+	file = file[1 : len(file)-1]
+	row, col, err = lineparse.RowColumnFromFields(commaSplit[len(commaSplit)-2 : len(commaSplit)])
+	return
+}
+
+// Extract the line and column numbers from a string with format:
+// `[at] line x, column y` where x and y are integers
+func extractRowColumnFromString(input string) (row, column int, err error) {
+	fields := strings.FieldsFunc(input, lineparse.MatchCommaRune)
+	row, column, err = lineparse.RowColumnFromFields(fields)
+	return
+}
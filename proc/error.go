@@ -0,0 +1,104 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MagmaError represents an error reported by Magma in the output of a
+// statement: the error message (EU, ER, EI or ENE tags) along with any
+// preceding position (POS), error position (EPO) and traceback (TB) output.
+type MagmaError struct {
+	Kind          Tag            // TagErrorUser, TagErrorRuntime, TagErrorInternal or TagErrorSyntax
+	Message       string         // Error message
+	Position      *Position      // Position of the error in the input (if given)
+	ErrorPosition *ErrorPosition // Parsed error position chain (nil if not given)
+	Traceback     []*Traceback   // Parsed traceback (outermost last)
+}
+
+// Error implements error.
+func (e *MagmaError) Error() string {
+	switch {
+	case e.Message != "":
+	case e.Kind == TagErrorSyntax:
+		return "magma/proc: input ended before statement was complete"
+	default:
+		return fmt.Sprintf("magma/proc: %v error", e.Kind)
+	}
+	return fmt.Sprintf("magma/proc: %v", e.Message)
+}
+
+// NewMagmaError creates a *MagmaError from the output of a statement.
+// Returns nil if the output does not contain an error message.  Error
+// position and traceback output which cannot be parsed is ignored.
+func NewMagmaError(output []Tagged) *MagmaError {
+	e := &MagmaError{}
+	var msg bytes.Buffer
+	var epo, tb []string
+	for _, x := range output {
+		switch x := x.(type) {
+		case *Position:
+			p := *x
+			e.Position = &p
+
+		case *Line:
+			switch x.Tag() {
+			case TagErrorPosition:
+				epo = appendData(epo, x)
+
+			case TagTraceback:
+				tb = appendData(tb, x)
+
+			case TagErrorUser, TagErrorRuntime, TagErrorInternal, TagErrorSyntax:
+				if e.Kind == "" {
+					e.Kind = x.Tag()
+				} else if !x.Continuation && msg.Len() > 0 {
+					msg.Write(nl)
+				}
+				msg.WriteString(x.Data)
+			}
+		}
+	}
+
+	if e.Kind == "" {
+		return nil
+	}
+	e.Message = msg.String()
+	e.ErrorPosition, _ = ParseErrorPosition(epo)
+	e.Traceback, _ = ParseTraceback(tb)
+	return e
+}
+
+// appendData appends the data of l to lines, joining it to the last line if
+// l is a continuation.
+func appendData(lines []string, l *Line) []string {
+	if l.Continuation && len(lines) > 0 {
+		lines[len(lines)-1] += l.Data
+		return lines
+	}
+	return append(lines, l.Data)
+}
+
+// Err returns a *MagmaError if the statement output contains an error, or
+// nil otherwise.
+func (s *Statement) Err() error {
+	if e := NewMagmaError(s.Output); e != nil {
+		return e
+	}
+	return nil
+}
+
+// Err returns the error from the first statement which failed, or nil if
+// no statement gave an error.
+func (r *Result) Err() error {
+	for _, s := range r.Statements {
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewMagmaError(t *testing.T) {
+	out := []Tagged{
+		&Line{tag: TagOutput, Data: "output"},
+		&Line{tag: TagErrorPosition, Data: "In file \"/tmp/f.m\", line 2, column 10:"},
+		&Line{tag: TagErrorPosition, Data: ">> return x mod 0;"},
+		&Line{tag: TagErrorPosition, Data: "Located in:"},
+		&Line{tag: TagErrorPosition, Data: ">> f(3);"},
+		&Line{tag: TagTraceback, Data: "#0 *f("},
+		&Line{tag: TagTraceback, Data: "    x: 3"},
+		&Line{tag: TagTraceback, Data: ") at /tmp/f.m:2"},
+		&Line{tag: TagTraceback, Data: "#1 <main>("},
+		&Line{tag: TagTraceback, Data: ") at <main>:1"},
+		&Position{Row: 1, Column: 2},
+		&Line{tag: TagErrorUser, Data: "User error: first"},
+		&Line{tag: TagErrorUser, Data: " part", Continuation: true},
+		&Line{tag: TagErrorUser, Data: "second"},
+	}
+
+	e := NewMagmaError(out)
+	if e == nil {
+		t.Fatalf("expected *MagmaError, got nil")
+	}
+	if e.Kind != TagErrorUser {
+		t.Errorf("expected Kind %v, got %v", TagErrorUser, e.Kind)
+	}
+	if e.Message != "User error: first part\nsecond" {
+		t.Errorf("unexpected Message: %q", e.Message)
+	}
+	if e.Position == nil || *e.Position != (Position{Row: 1, Column: 2}) {
+		t.Errorf("unexpected Position: %v", e.Position)
+	}
+
+	epo := &ErrorPosition{
+		File:           "/tmp/f.m",
+		Row:            2,
+		Column:         10,
+		SourceFragment: "return x mod 0;",
+		LocatedIn:      &ErrorPosition{SourceFragment: "f(3);"},
+	}
+	if !reflect.DeepEqual(e.ErrorPosition, epo) {
+		t.Errorf("expected ErrorPosition %#v, got %#v", epo, e.ErrorPosition)
+	}
+
+	tb := []*Traceback{
+		{Index: 0, Current: true, Name: "f", Params: []ParamValue{{"x", "3"}}, Location: Location{File: "/tmp/f.m", Row: 2}},
+		{Index: 1, Name: "<main>", Location: Location{File: "<main>", Row: 1}},
+	}
+	if !reflect.DeepEqual(e.Traceback, tb) {
+		t.Errorf("expected Traceback %#v, got %#v", tb, e.Traceback)
+	}
+
+	if e := NewMagmaError(out[:1]); e != nil {
+		t.Errorf("expected nil error for output without errors, got: %v", e)
+	}
+	if e := NewMagmaError([]Tagged{&Line{tag: TagErrorSyntax}}); e == nil || e.Error() == "" {
+		t.Errorf("expected *MagmaError for ENE output, got: %v", e)
+	}
+}
+
+func TestResultErr(t *testing.T) {
+	test := func(p *Process, t errorfer) {
+		defer testQuitAndWait(p, t)
+
+		res, err := p.Run("1 mod 0;")
+		checkFatalf(t, "Run() error: %v", err)
+
+		var e *MagmaError
+		if !errors.As(res.Err(), &e) {
+			t.Fatalf("expected *MagmaError from Result.Err(), got: %v", res.Err())
+		}
+		if e.Kind != TagErrorRuntime || e.Message != "Runtime error in 'mod': Division by zero" {
+			t.Errorf("unexpected error: %#v", e)
+		}
+		if e.Position == nil || e.Position.Column != 2 {
+			t.Errorf("expected Position with Column 2, got: %v", e.Position)
+		}
+
+		res, err = p.Run("a := 1;")
+		checkFatalf(t, "Run() error: %v", err)
+		checkErrorf(t, "unexpected Result.Err(): %v", res.Err())
+	}
+	runProcess(test, t)
+}
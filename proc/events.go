@@ -0,0 +1,166 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind identifies the type of an Event.
+type EventKind int
+
+// Kinds of Event.
+const (
+	EventStatus  EventKind = iota // A Ready or Status tag was received (see Event.Tag)
+	EventStarted                  // The process has started
+	EventExited                   // The process output has ended
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStatus:
+		return "status"
+	case EventStarted:
+		return "started"
+	case EventExited:
+		return "exited"
+	}
+	return "unknown"
+}
+
+// Event is a status or lifecycle event from a Magma session.
+type Event struct {
+	Kind EventKind
+	Tag  Tagged    // *Ready or *Status (EventStatus only)
+	Time time.Time // Time the event occurred
+}
+
+// Policy determines what happens when an event is published to a
+// Subscription with a full buffer.
+type Policy int
+
+// Subscription policies.
+const (
+	// PolicyDrop discards the event (see Subscription.Dropped).
+	PolicyDrop Policy = iota
+
+	// PolicyBlock waits for the event to be received, blocking the processing
+	// of Magma output until it is (or the Subscription is closed).
+	PolicyBlock
+)
+
+// Subscription receives events from a Magma session (see Process.Subscribe).
+type Subscription struct {
+	// C receives the events.  It is closed when the Subscription is closed.
+	C <-chan Event
+
+	c       chan Event
+	policy  Policy
+	dropped uint64
+
+	mu     sync.Mutex // Held whilst sending to c
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+	bus    *bus
+}
+
+// Dropped returns the number of events discarded because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close detaches the Subscription, and closes C.  Any blocked send is
+// abandoned.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.bus.remove(s)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.c)
+	})
+}
+
+// send passes e to the subscription according to its policy.
+func (s *Subscription) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	if s.policy == PolicyBlock {
+		select {
+		case s.c <- e:
+		case <-s.done:
+		}
+		return
+	}
+
+	select {
+	case s.c <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// bus passes events to subscriptions.
+type bus struct {
+	mu   sync.Mutex
+	subs []*Subscription
+}
+
+func (b *bus) add(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, s)
+}
+
+func (b *bus) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, x := range b.subs {
+		if x == s {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// publish sends an event of kind k (with tag t) to all the subscriptions, in
+// the order they were added.
+func (b *bus) publish(k EventKind, t Tagged) {
+	e := Event{Kind: k, Tag: t, Time: time.Now()}
+
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.send(e)
+	}
+}
+
+// Subscribe attaches a new Subscription to the events of the session, with
+// the given buffer size and policy for when the buffer is full.  Subscribe
+// can be called at any time, and subscriptions remain attached (including
+// across calls to Start) until they are closed.
+func (p *Process) Subscribe(buffer int, policy Policy) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{
+		C:      c,
+		c:      c,
+		policy: policy,
+		done:   make(chan struct{}),
+		bus:    &p.events,
+	}
+	p.events.add(s)
+	return s
+}
@@ -0,0 +1,121 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	p := newTestProcess()
+	all := p.Subscribe(0, PolicyBlock)
+	events := make(chan []Event, 1)
+	go func() {
+		var ev []Event
+		for e := range all.C {
+			ev = append(ev, e)
+			if e.Kind == EventExited {
+				all.Close()
+			}
+		}
+		events <- ev
+	}()
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	_, err = p.ReadStartup(so)
+	checkFatalf(t, "ReadStartup() error: %v", err)
+
+	// Attached after start, and never read
+	unread := p.Subscribe(1, PolicyDrop)
+
+	_, err = p.Run("1;")
+	checkFatalf(t, "Run() error: %v", err)
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+
+	var ev []Event
+	select {
+	case ev = <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for events")
+	}
+
+	if len(ev) < 3 || ev[0].Kind != EventStarted || ev[len(ev)-1].Kind != EventExited {
+		t.Fatalf("expected started, status and exited events, got %v", ev)
+	}
+	for i, e := range ev[1 : len(ev)-1] {
+		if e.Kind != EventStatus || e.Tag == nil {
+			t.Errorf("expected status event, got %+v", e)
+		}
+		if e.Time.Before(ev[i].Time) {
+			t.Errorf("event %+v is earlier than %+v", e, ev[i])
+		}
+	}
+	if _, ok := ev[1].Tag.(*Ready); !ok {
+		t.Errorf("expected first status event to be *Ready, got %v", ev[1].Tag)
+	}
+
+	e := <-unread.C
+	if e.Kind != EventStatus {
+		t.Errorf("expected status event, got %+v", e)
+	}
+	if unread.Dropped() == 0 {
+		t.Errorf("expected dropped events")
+	}
+	unread.Close()
+	unread.Close()
+	if _, ok := <-unread.C; ok {
+		t.Errorf("expected closed channel after Close()")
+	}
+}
+
+func TestSubscribeCloseUnblocks(t *testing.T) {
+	p := newTestProcess()
+	s := p.Subscribe(0, PolicyBlock)
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+
+	started := make(chan error, 1)
+	go func() {
+		_, err := p.ReadStartup(so)
+		started <- err
+	}()
+
+	e := <-s.C
+	if e.Kind != EventStarted {
+		t.Errorf("expected started event, got %+v", e)
+	}
+	s.Close()
+
+	select {
+	case err := <-started:
+		checkErrorf(t, "ReadStartup() error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("blocked subscription was not detached by Close()")
+	}
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
+
+func TestStatusTagsClosed(t *testing.T) {
+	p := &Process{Command: "magma-does-not-exist"}
+	st, err := p.StatusTags()
+	checkFatalf(t, "StatusTags() error: %v", err)
+	if _, err := p.Start(); err == nil {
+		t.Fatalf("expected Start() error")
+	}
+
+	select {
+	case _, ok := <-st:
+		if ok {
+			t.Errorf("expected StatusTags() channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("timed out waiting for StatusTags() channel to be closed")
+	}
+}
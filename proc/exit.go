@@ -0,0 +1,131 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Limits gives resource limits for a Magma process (see Process.Limits).
+// Zero values are not applied.  Limits are only supported on Linux.
+type Limits struct {
+	AddressSpace uint64        // Maximum size of the address space in bytes (RLIMIT_AS), rounded up to the KiB
+	CPUTime      time.Duration // Maximum CPU time (RLIMIT_CPU), rounded up to the second
+	OpenFiles    uint64        // Maximum number of open files (RLIMIT_NOFILE)
+}
+
+// ExitReason describes why a Magma process ended.
+type ExitReason int
+
+// Reasons for the end of a Magma process.
+const (
+	ExitNormal      ExitReason = iota // Exited with zero status
+	ExitStatus                        // Exited with non-zero status
+	ExitSignal                        // Ended by a signal
+	ExitCPULimit                      // CPU time limit exceeded
+	ExitMemoryLimit                   // Address space limit exceeded
+)
+
+func (r ExitReason) String() string {
+	switch r {
+	case ExitNormal:
+		return "exited"
+	case ExitStatus:
+		return "exited with non-zero status"
+	case ExitSignal:
+		return "ended by signal"
+	case ExitCPULimit:
+		return "CPU time limit exceeded"
+	case ExitMemoryLimit:
+		return "memory limit exceeded"
+	}
+	return "unknown"
+}
+
+// Exit describes how a Magma process ended (see Process.Exit).
+type Exit struct {
+	Reason  ExitReason
+	Code    int            // Exit status (-1 if ended by a signal)
+	Signal  syscall.Signal // Signal which ended the process (if any)
+	CPUTime time.Duration  // User and system CPU time used
+	MaxRSS  int64          // Maximum resident set size in bytes (if known)
+}
+
+// ExitError is returned by Wait when the Magma process did not exit normally.
+type ExitError struct {
+	Exit *Exit
+	Err  error // Underlying error
+}
+
+// Error implements error.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("magma/proc: process %v: %v", e.Exit.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Exit returns how the Magma process ended, or nil if Wait has not returned.
+func (p *Process) Exit() *Exit {
+	p.exitMu.Lock()
+	defer p.exitMu.Unlock()
+	return p.exit
+}
+
+// setExit sets the Exit returned by p.Exit.
+func (p *Process) setExit(e *Exit) {
+	p.exitMu.Lock()
+	defer p.exitMu.Unlock()
+	p.exit = e
+}
+
+// newExit creates an Exit from the process state ps.  The CPU time limit in l
+// is taken to be exceeded if the process was ended by SIGXCPU, or killed
+// after using at least the limit.  The address space limit is taken to be
+// exceeded if the process was ended by one of the signals with which a
+// failed allocation usually ends a process (see memorySignal).  This is only
+// a guess: the kernel does not report why an allocation failed, so a crash
+// by one of these signals for another reason is reported in the same way.
+func newExit(ps *os.ProcessState, l *Limits) *Exit {
+	e := &Exit{
+		Code:    ps.ExitCode(),
+		CPUTime: ps.UserTime() + ps.SystemTime(),
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		e.Signal = ws.Signal()
+	}
+	e.MaxRSS = maxRSS(ps)
+
+	switch {
+	case e.Code == 0:
+		e.Reason = ExitNormal
+	case l != nil && l.CPUTime > 0 && (e.Signal == sigCPULimit ||
+		e.Signal == syscall.SIGKILL && e.CPUTime >= l.CPUTime):
+		e.Reason = ExitCPULimit
+	case l != nil && l.AddressSpace > 0 && memorySignal(e.Signal):
+		e.Reason = ExitMemoryLimit
+	case e.Signal != 0:
+		e.Reason = ExitSignal
+	default:
+		e.Reason = ExitStatus
+	}
+	return e
+}
+
+// memorySignal returns true if sig is a signal which ends a process that
+// could not allocate memory: SIGSEGV (the stack could not grow, or a failed
+// allocation was used), SIGBUS, or SIGABRT (an allocator gave up).
+func memorySignal(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGABRT:
+		return true
+	}
+	return false
+}
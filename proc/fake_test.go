@@ -0,0 +1,198 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhowden/magma/proc/magmatest"
+)
+
+// Names of the fake Magmas registered for these tests.
+const (
+	fakeName           = "proc"
+	fakeFailedName     = "proc-failed"
+	fakeBannerName     = "proc-banner"
+	fakeNotXModeName   = "proc-not-x-mode"
+	fakeBadOptionName  = "proc-bad-option"
+	fakeSilentFailName = "proc-silent-fail"
+	fakeHungName       = "proc-hung"
+	fakeLongLinesName  = "proc-long-lines"
+)
+
+// fakeBanner is the startup banner given by the fake Magma registered with
+// fakeBannerName.
+var fakeBanner = []string{
+	"Magma V2.20-10     Mon Oct 27 2014 10:00:00 on host     [Seed = 2849493729]",
+	"Licensed to: Test User",
+	"Type ? for help.  Type <Ctrl>-D to quit.",
+}
+
+// fakeFailedStderr is written to stderr by the fake Magma which fails to start.
+const fakeFailedStderr = "Unable to open licence file\n"
+
+// newFake returns a fake Magma which gives the output expected by the tests
+// in this package.
+func newFake() *magmatest.Fake {
+	f := &magmatest.Fake{Seed: 1}
+
+	f.Handle("p();", func(s *magmatest.Stmt) {
+		s.IndentPush()
+		s.Print(strings.Repeat("X", 1025))
+		s.IndentPop()
+	})
+
+	f.Handle("1 mod 0;", func(s *magmatest.Stmt) {
+		s.Traceback("")
+		s.Position(0, 2)
+		s.RuntimeError("Runtime error in 'mod': Division by zero")
+	})
+
+	f.Handle("crash();", func(s *magmatest.Stmt) {
+		os.Exit(3)
+	})
+
+	f.Handle("ei();", func(s *magmatest.Stmt) {
+		s.InternalError("Magma: Internal error")
+	})
+
+	f.HandleParseError("1 +;", "User error: bad syntax")
+
+	f.HandleRegexp(regexp.MustCompile(`AttachSpec\(".*bad\.spec"\);`), func(s *magmatest.Stmt) {
+		s.RuntimeError("Runtime error in 'AttachSpec': Invalid spec file")
+	})
+
+	f.Handle("while i lt 1 do print i; end while;", func(s *magmatest.Stmt) {
+		for {
+			select {
+			case <-s.Interrupted():
+				return
+			case <-time.After(10 * time.Millisecond):
+				s.Print("0")
+			}
+		}
+	})
+
+	f.Handle("while true do end while;", func(s *magmatest.Stmt) {
+		// Ignores interrupts (a second interrupt makes the fake quit)
+		select {}
+	})
+
+	f.Handle("repeat i := 1; until false;", func(s *magmatest.Stmt) {
+		// Busy loop which ignores interrupts (uses CPU time)
+		for {
+		}
+	})
+
+	f.Handle("System(\"sleep 60\");", func(s *magmatest.Stmt) {
+		c := exec.Command("sleep", "60")
+		if err := c.Start(); err != nil {
+			s.Print(err.Error())
+			return
+		}
+		s.Print(strconv.Itoa(c.Process.Pid))
+		c.Wait()
+	})
+
+	f.Handle("System(\"head -c 262144 /dev/zero >&2\");", func(s *magmatest.Stmt) {
+		os.Stderr.Write(make([]byte, 262144))
+	})
+
+	f.Handle("System(\"sleep 60 &\"); quit;", func(s *magmatest.Stmt) {
+		// The child holds stderr open after the fake exits
+		c := exec.Command("sleep", "60")
+		c.Stderr = os.Stderr
+		if err := c.Start(); err != nil {
+			s.Print(err.Error())
+			return
+		}
+		os.Exit(0)
+	})
+
+	f.Handle("for i in [1..10] do printf \"X\"; end for;", func(s *magmatest.Stmt) {
+		s.Printf(strings.Repeat("X", 10))
+	})
+
+	f.Handle("for i in [1..1025] do\n\tPrompt cat:= \"X\";\nend for;", func(s *magmatest.Stmt) {
+		p, _ := s.Get("Prompt")
+		s.Set("Prompt", p+strings.Repeat("X", 1025))
+	})
+
+	f.Handle("f(3);", func(s *magmatest.Stmt) {
+		s.Print("start")
+		s.Debug(debugCommand)
+		s.Print("done")
+	})
+
+	f.HandleRegexp(regexp.MustCompile(`print "X"\^(\d+);`), func(s *magmatest.Stmt) {
+		n, _ := strconv.Atoi(s.Match[1])
+		s.Print(strings.Repeat("X", n))
+	})
+
+	f.HandleRegexp(regexp.MustCompile(`for i in \[1\.\.(\d+)\+1\] do print "(X+)"; end for;`), func(s *magmatest.Stmt) {
+		n, _ := strconv.Atoi(s.Match[1])
+		for i := 0; i < n+1; i++ {
+			s.Print(s.Match[2])
+		}
+	})
+	return f
+}
+
+// debugCommand runs debugger commands whilst stopped in f(x) with x = 3.
+func debugCommand(s *magmatest.Stmt, cmd string) bool {
+	switch {
+	case cmd == "continue":
+		return false
+	case strings.HasPrefix(cmd, "break "):
+		s.Printf("Breakpoint set at %v\n", strings.TrimPrefix(cmd, "break "))
+	case cmd == "step", cmd == "next", cmd == "finish":
+		s.Print("x := x + 1;")
+	case cmd == "bt":
+		s.Traceback("#0 *f(\n    x: 3\n) at /tmp/f.m:2")
+	case cmd == "print x":
+		s.Print("3")
+	default:
+		s.UserError("Identifier '" + strings.TrimPrefix(cmd, "print ") + "' has not been declared or assigned")
+	}
+	return true
+}
+
+func TestMain(m *testing.M) {
+	magmatest.Register(fakeName, newFake())
+	magmatest.Register(fakeFailedName, &magmatest.Fake{Stderr: fakeFailedStderr, Exit: 1})
+	magmatest.Register(fakeBannerName, &magmatest.Fake{Startup: fakeBanner})
+	magmatest.Register(fakeNotXModeName, &magmatest.Fake{Banner: fakeBanner[:1], Exit: 1})
+	magmatest.Register(fakeBadOptionName, &magmatest.Fake{Stderr: "magma: unknown option -x\n", Exit: 2})
+	magmatest.Register(fakeSilentFailName, &magmatest.Fake{Exit: 1})
+	hung := newFake()
+	hung.IgnoreInterrupts = true
+	magmatest.Register(fakeHungName, hung)
+	long := newFake()
+	long.LineLength = -1
+	magmatest.Register(fakeLongLinesName, long)
+	magmatest.Main()
+	os.Exit(m.Run())
+}
+
+// newTestProcess returns a new Process which runs Magma, or the fake Magma if
+// there is no Magma on the PATH.
+func newTestProcess() *Process {
+	if _, err := exec.LookPath(DefaultCommand); err == nil {
+		return &Process{}
+	}
+	return newFakeProcess()
+}
+
+// newFakeProcess returns a new Process which runs the fake Magma.
+func newFakeProcess() *Process {
+	cmd, env := magmatest.Command(fakeName)
+	return &Process{Command: cmd, Env: env}
+}
@@ -0,0 +1,95 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InputPolicy determines how input containing characters which would corrupt
+// the framing of the Magma protocol is handled (see Process.InputPolicy).
+// These are characters encoded (see Process.Encoding) as the single byte ^D
+// (which runs a command) or 129 (which prefixes tag lines).  In UTF-8, byte
+// 129 otherwise only occurs within multi-byte characters (such as 'Á', 0xc3
+// 0x81) which are valid, so only a stray byte 129 is invalid; in Latin-1 this
+// also includes U+0081.  For input to read statements, line breaks are also
+// invalid.  Magma has no escape for these characters, so they can only be
+// rejected or removed.
+type InputPolicy int
+
+// Input policies.
+const (
+	InputReject InputPolicy = iota // Return an *InvalidInputError
+	InputStrip                     // Remove invalid characters
+)
+
+func (p InputPolicy) String() string {
+	switch p {
+	case InputReject:
+		return "reject"
+	case InputStrip:
+		return "strip"
+	}
+	return "unknown"
+}
+
+// InvalidInputError is returned when input contains a character which
+// would corrupt the framing of the Magma protocol, or which cannot be encoded
+// (see Process.Encoding).
+type InvalidInputError struct {
+	Offset int // Byte offset of the first invalid character in the input
+	Char   int // Code of the invalid character
+}
+
+// Error implements error.
+func (e *InvalidInputError) Error() string {
+	return fmt.Sprintf("magma/proc: invalid input character %#02x at offset %d", e.Char, e.Offset)
+}
+
+// invalidChar returns the code of the invalid character at the start of s
+// (or -1 if it is valid), and its length in s.  Characters which are encoded
+// using e as the single byte ^D or 129 are reported by the value of the byte.
+func invalidChar(s string, read bool, e Encoding) (int, int) {
+	r, enc, n := e.encodeChar(s)
+	switch {
+	case len(enc) == 1 && (enc[0] == runCommandChar || enc[0] == newTagChar):
+		return int(enc[0]), n
+	case read && (r == '\n' || r == '\r'):
+		return int(r), n
+	}
+	return -1, n
+}
+
+// sanitizeInput applies the policy to the input s, which is to be encoded
+// using e.  If read is true then s is input for a read statement, and line
+// breaks are also invalid.
+func sanitizeInput(s string, policy InputPolicy, read bool, e Encoding) (string, error) {
+	var b *strings.Builder
+	for i := 0; i < len(s); {
+		c, n := invalidChar(s[i:], read, e)
+		if c < 0 {
+			if b != nil {
+				b.WriteString(s[i : i+n])
+			}
+			i += n
+			continue
+		}
+
+		if policy != InputStrip {
+			return "", &InvalidInputError{Offset: i, Char: c}
+		}
+		if b == nil {
+			b = &strings.Builder{}
+			b.WriteString(s[:i])
+		}
+		i += n
+	}
+
+	if b == nil {
+		return s, nil
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSanitizeInput(t *testing.T) {
+	tests := []struct {
+		in      string
+		policy  InputPolicy
+		read    bool
+		enc     Encoding
+		out     string
+		invalid *InvalidInputError
+	}{
+		{"x := \"éà\";", InputReject, false, EncodingAuto, "x := \"éà\";", nil},
+		{"1;\x042;", InputReject, false, EncodingAuto, "", &InvalidInputError{Offset: 2, Char: 4}},
+		{"a\x81b", InputReject, false, EncodingAuto, "", &InvalidInputError{Offset: 1, Char: 129}},
+		{"a\u0081b", InputReject, false, EncodingAuto, "a\u0081b", nil},
+		{"a\x81b", InputReject, false, EncodingLatin1, "", &InvalidInputError{Offset: 1, Char: 129}},
+		{"a\u0081b", InputReject, false, EncodingLatin1, "", &InvalidInputError{Offset: 1, Char: 129}},
+
+		// Byte 129 within multi-byte UTF-8 characters: 'Á' (0xc3 0x81), 'ā'
+		// (0xc4 0x81), 'Ё' (0xd0 0x81) and 'ぁ' (0xe3 0x81 0x81)
+		{"x := \"ÁāЁぁ\";", InputReject, false, EncodingAuto, "x := \"ÁāЁぁ\";", nil},
+		{"x := \"ÁāЁぁ\";", InputReject, false, EncodingUTF8, "x := \"ÁāЁぁ\";", nil},
+		{"x := \"éÁ\";", InputReject, false, EncodingLatin1, "x := \"éÁ\";", nil},
+
+		{"a\nb", InputReject, false, EncodingAuto, "a\nb", nil},
+		{"a\nb", InputReject, true, EncodingAuto, "", &InvalidInputError{Offset: 1, Char: '\n'}},
+		{"1;\x04\x81\u0081Á2;", InputStrip, false, EncodingAuto, "1;\u0081Á2;", nil},
+		{"1;\x04\x81\u0081Á2;", InputStrip, false, EncodingLatin1, "1;Á2;", nil},
+		{"a\r\nb", InputStrip, true, EncodingAuto, "ab", nil},
+	}
+
+	for _, tt := range tests {
+		out, err := sanitizeInput(tt.in, tt.policy, tt.read, tt.enc)
+		if tt.invalid != nil {
+			var e *InvalidInputError
+			if !errors.As(err, &e) || *e != *tt.invalid {
+				t.Errorf("sanitizeInput(%q, %v, %v): expected error %v, got %v", tt.in, tt.policy, tt.enc, tt.invalid, err)
+			}
+			continue
+		}
+		checkErrorf(t, "sanitizeInput() error: %v", err)
+		if out != tt.out {
+			t.Errorf("sanitizeInput(%q, %v, %v): expected %q, got %q", tt.in, tt.policy, tt.enc, tt.out, out)
+		}
+	}
+}
+
+func TestExecuteInvalidInput(t *testing.T) {
+	p := newTestProcess()
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	var e *InvalidInputError
+	if _, err := p.Execute("1;\x04print 2;"); !errors.As(err, &e) {
+		t.Errorf("expected *InvalidInputError, got %v", err)
+	}
+
+	p.InputPolicy = InputStrip
+	res, err := p.Run("1\x04;")
+	checkFatalf(t, "Run() error: %v", err)
+	if res.Command != "1;" || res.Text() != "1" {
+		t.Errorf("expected command %q with output %q, got %q with output %q", "1;", "1", res.Command, res.Text())
+	}
+
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
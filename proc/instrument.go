@@ -0,0 +1,80 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import "time"
+
+// Instrumentation receives measurements from a Process, Pool or Supervisor
+// (see Process.Instrumentation, Pool.Instrumentation and
+// Supervisor.Instrumentation).  Methods are called from the goroutines which
+// run the session, so must be safe for concurrent use and should return
+// promptly.  See package instrument for implementations.
+type Instrumentation interface {
+	// CommandStarted is called by Execute as a command is sent to Magma.
+	CommandStarted(cmd string)
+
+	// CommandDone is called when Magma is ready for input after running a
+	// command.
+	CommandDone(s CommandStats)
+
+	// Interrupted is called when Magma acknowledges an interrupt (INT tag).
+	Interrupted()
+
+	// ParseError is called when Magma fails to parse a statement (ERP tag).
+	ParseError()
+
+	// InternalError is called when Magma reports an internal error (EI tag).
+	InternalError()
+
+	// WorkerRestarted is called when a Pool replaces a worker whose process
+	// has exited, or a Supervisor restarts its process.
+	WorkerRestarted()
+
+	// Replayed is called when a Supervisor has replayed its statements on a
+	// restarted process, with the number which were replayed successfully and
+	// the number which failed.
+	Replayed(replayed, failed int)
+
+	// QueueWait is called with the time an Execute call on a Pool waited for
+	// an idle worker.
+	QueueWait(d time.Duration)
+}
+
+// CommandStats gives measurements of a command run by Magma.
+type CommandStats struct {
+	Command      string
+	Latency      time.Duration    // Time from input received (IR tag) to ready (RDY tag)
+	Statements   int              // Number of statements run
+	OutputBytes  int64            // Bytes of line data output
+	PerStatement []StatementStats // Measurements of each statement run
+}
+
+// StatementStats gives measurements of a statement run by Magma, identified
+// by its span in the command (see Run).
+type StatementStats struct {
+	Start, End  Position
+	OutputBytes int64 // Bytes of line data output
+}
+
+// nopInstrumentation is an Instrumentation which does nothing.
+type nopInstrumentation struct{}
+
+func (nopInstrumentation) CommandStarted(string)     {}
+func (nopInstrumentation) CommandDone(CommandStats)  {}
+func (nopInstrumentation) Interrupted()              {}
+func (nopInstrumentation) ParseError()               {}
+func (nopInstrumentation) InternalError()            {}
+func (nopInstrumentation) WorkerRestarted()          {}
+func (nopInstrumentation) Replayed(int, int)         {}
+func (nopInstrumentation) QueueWait(d time.Duration) {}
+
+// instrumentation returns p.Instrumentation, or an Instrumentation which does
+// nothing if it is not set.
+func (p *Process) instrumentation() Instrumentation {
+	if p.Instrumentation == nil {
+		return nopInstrumentation{}
+	}
+	return p.Instrumentation
+}
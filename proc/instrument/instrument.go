@@ -0,0 +1,70 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package instrument provides implementations of proc.Instrumentation: Slog
+// logs measurements with log/slog, and Metrics collects them for export in
+// the Prometheus text format.  Use Multi to combine them.
+package instrument
+
+import (
+	"time"
+
+	"github.com/dhowden/magma/proc"
+)
+
+// Multi returns an Instrumentation which passes all measurements to each of
+// the given Instrumentations in turn.
+func Multi(is ...proc.Instrumentation) proc.Instrumentation {
+	return multi(append([]proc.Instrumentation(nil), is...))
+}
+
+type multi []proc.Instrumentation
+
+func (m multi) CommandStarted(cmd string) {
+	for _, i := range m {
+		i.CommandStarted(cmd)
+	}
+}
+
+func (m multi) CommandDone(s proc.CommandStats) {
+	for _, i := range m {
+		i.CommandDone(s)
+	}
+}
+
+func (m multi) Interrupted() {
+	for _, i := range m {
+		i.Interrupted()
+	}
+}
+
+func (m multi) ParseError() {
+	for _, i := range m {
+		i.ParseError()
+	}
+}
+
+func (m multi) InternalError() {
+	for _, i := range m {
+		i.InternalError()
+	}
+}
+
+func (m multi) WorkerRestarted() {
+	for _, i := range m {
+		i.WorkerRestarted()
+	}
+}
+
+func (m multi) Replayed(replayed, failed int) {
+	for _, i := range m {
+		i.Replayed(replayed, failed)
+	}
+}
+
+func (m multi) QueueWait(d time.Duration) {
+	for _, i := range m {
+		i.QueueWait(d)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package instrument
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhowden/magma/proc"
+)
+
+func TestMetrics(t *testing.T) {
+	m := &Metrics{Buckets: []float64{0.1, 1}, ByteBuckets: []float64{1, 10}}
+	m.CommandDone(proc.CommandStats{Command: "1;", Latency: 50 * time.Millisecond, Statements: 1, OutputBytes: 1,
+		PerStatement: []proc.StatementStats{{OutputBytes: 1}}})
+	m.CommandDone(proc.CommandStats{Command: "2;", Latency: 2 * time.Second, Statements: 2, OutputBytes: 3,
+		PerStatement: []proc.StatementStats{{OutputBytes: 1}, {OutputBytes: 2}}})
+	m.ParseError()
+	m.InternalError()
+	m.Interrupted()
+	m.WorkerRestarted()
+	m.Replayed(3, 1)
+	m.QueueWait(500 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != contentType {
+		t.Errorf("expected Content-Type %q, got %q", contentType, ct)
+	}
+
+	body := w.Body.String()
+	for _, l := range []string{
+		"# TYPE magma_commands_total counter",
+		"magma_commands_total 2",
+		"magma_statements_total 3",
+		"magma_output_bytes_total 4",
+		"magma_interrupts_total 1",
+		"magma_parse_errors_total 1",
+		"magma_internal_errors_total 1",
+		"magma_worker_restarts_total 1",
+		"magma_replayed_statements_total 3",
+		"magma_replay_failures_total 1",
+		"# TYPE magma_command_latency_seconds histogram",
+		`magma_command_latency_seconds_bucket{le="0.1"} 1`,
+		`magma_command_latency_seconds_bucket{le="1"} 1`,
+		`magma_command_latency_seconds_bucket{le="+Inf"} 2`,
+		"magma_command_latency_seconds_sum 2.05",
+		"magma_command_latency_seconds_count 2",
+		`magma_queue_wait_seconds_bucket{le="0.1"} 0`,
+		`magma_queue_wait_seconds_bucket{le="1"} 1`,
+		"magma_queue_wait_seconds_count 1",
+		`magma_statement_output_bytes_bucket{le="1"} 2`,
+		`magma_statement_output_bytes_bucket{le="10"} 3`,
+		"magma_statement_output_bytes_sum 4",
+	} {
+		if !strings.Contains(body, l+"\n") {
+			t.Errorf("expected line %q in output:\n%v", l, body)
+		}
+	}
+}
+
+func TestMetricsZero(t *testing.T) {
+	m := &Metrics{}
+	n, err := m.WriteTo(io.Discard)
+	if err != nil || n == 0 {
+		t.Errorf("expected WriteTo() to write output, got %d, %v", n, err)
+	}
+}
+
+func TestSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Slog{Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))}
+
+	var i proc.Instrumentation = l
+	i.CommandStarted("1;")
+	i.CommandDone(proc.CommandStats{Command: "1;", Statements: 1, OutputBytes: 1,
+		PerStatement: []proc.StatementStats{{OutputBytes: 1}}})
+	i.ParseError()
+
+	out := buf.String()
+	if strings.Contains(out, "started") {
+		t.Errorf("expected debug messages to be dropped, got:\n%v", out)
+	}
+	for _, s := range []string{`msg="magma command done"`, "statements=1", "statement_output_bytes=[1]", "level=WARN", `msg="magma parse error"`} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in output:\n%v", s, out)
+		}
+	}
+}
+
+func TestMulti(t *testing.T) {
+	m1, m2 := &Metrics{}, &Metrics{}
+	i := Multi(m1, m2)
+	i.Interrupted()
+	i.CommandDone(proc.CommandStats{})
+	for _, m := range []*Metrics{m1, m2} {
+		if m.interrupts != 1 || m.commands != 1 {
+			t.Errorf("expected 1 interrupt and 1 command, got %d and %d", m.interrupts, m.commands)
+		}
+	}
+}
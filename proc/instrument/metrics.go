@@ -0,0 +1,213 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package instrument
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dhowden/magma/proc"
+)
+
+// DefaultBuckets are the default upper bounds (in seconds) of the buckets of
+// the histograms collected by Metrics.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60}
+
+// DefaultByteBuckets are the default upper bounds (in bytes) of the buckets of
+// the output size histogram collected by Metrics.
+var DefaultByteBuckets = []float64{0, 64, 1024, 16384, 262144, 4194304}
+
+// contentType is the content type of the Prometheus text format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metrics is an Instrumentation which collects counters and histograms of the
+// measurements, and serves them over HTTP in the Prometheus text format.  The
+// zero value is ready to use.
+type Metrics struct {
+	// Buckets gives the upper bounds (in seconds) of the buckets of the
+	// latency and queue wait histograms, in increasing order.  It must not be
+	// changed once the Metrics is in use.
+	//
+	// If nil, DefaultBuckets is used.
+	Buckets []float64
+
+	// ByteBuckets gives the upper bounds (in bytes) of the buckets of the
+	// statement output histogram, in increasing order.  It must not be changed
+	// once the Metrics is in use.
+	//
+	// If nil, DefaultByteBuckets is used.
+	ByteBuckets []float64
+
+	mu             sync.Mutex
+	commands       uint64
+	statements     uint64
+	outputBytes    uint64
+	interrupts     uint64
+	parseErrors    uint64
+	internalErrors uint64
+	restarts       uint64
+	replayed       uint64
+	replayFailures uint64
+	latency        histogram
+	queueWait      histogram
+	stmtOutput     histogram
+}
+
+// histogram counts observations in buckets.
+type histogram struct {
+	counts []uint64 // Non-cumulative count for each bucket
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	for i, b := range buckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (m *Metrics) buckets() []float64 {
+	if m.Buckets == nil {
+		return DefaultBuckets
+	}
+	return m.Buckets
+}
+
+func (m *Metrics) byteBuckets() []float64 {
+	if m.ByteBuckets == nil {
+		return DefaultByteBuckets
+	}
+	return m.ByteBuckets
+}
+
+// CommandStarted implements proc.Instrumentation.
+func (m *Metrics) CommandStarted(string) {}
+
+// CommandDone implements proc.Instrumentation.
+func (m *Metrics) CommandDone(s proc.CommandStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands++
+	m.statements += uint64(s.Statements)
+	m.outputBytes += uint64(s.OutputBytes)
+	m.latency.observe(m.buckets(), s.Latency.Seconds())
+	for _, st := range s.PerStatement {
+		m.stmtOutput.observe(m.byteBuckets(), float64(st.OutputBytes))
+	}
+}
+
+// Interrupted implements proc.Instrumentation.
+func (m *Metrics) Interrupted() { m.inc(&m.interrupts) }
+
+// ParseError implements proc.Instrumentation.
+func (m *Metrics) ParseError() { m.inc(&m.parseErrors) }
+
+// InternalError implements proc.Instrumentation.
+func (m *Metrics) InternalError() { m.inc(&m.internalErrors) }
+
+// WorkerRestarted implements proc.Instrumentation.
+func (m *Metrics) WorkerRestarted() { m.inc(&m.restarts) }
+
+// Replayed implements proc.Instrumentation.
+func (m *Metrics) Replayed(replayed, failed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replayed += uint64(replayed)
+	m.replayFailures += uint64(failed)
+}
+
+// QueueWait implements proc.Instrumentation.
+func (m *Metrics) QueueWait(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queueWait.observe(m.buckets(), d.Seconds())
+}
+
+func (m *Metrics) inc(n *uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*n++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	counter := func(name, help string, v uint64) {
+		fmt.Fprintf(cw, "# HELP %v %v\n# TYPE %v counter\n%v %d\n", name, help, name, name, v)
+	}
+	hist := func(name, help string, buckets []float64, h *histogram) {
+		fmt.Fprintf(cw, "# HELP %v %v\n# TYPE %v histogram\n", name, help, name)
+		var n uint64
+		for i, b := range buckets {
+			if h.counts != nil {
+				n += h.counts[i]
+			}
+			fmt.Fprintf(cw, "%v_bucket{le=\"%v\"} %d\n", name, formatFloat(b), n)
+		}
+		fmt.Fprintf(cw, "%v_bucket{le=\"+Inf\"} %d\n", name, h.count)
+		fmt.Fprintf(cw, "%v_sum %v\n%v_count %d\n", name, formatFloat(h.sum), name, h.count)
+	}
+
+	counter("magma_commands_total", "Commands run.", m.commands)
+	counter("magma_statements_total", "Statements run.", m.statements)
+	counter("magma_output_bytes_total", "Bytes of output from commands.", m.outputBytes)
+	counter("magma_interrupts_total", "Interrupts acknowledged by Magma.", m.interrupts)
+	counter("magma_parse_errors_total", "Statements which Magma failed to parse.", m.parseErrors)
+	counter("magma_internal_errors_total", "Internal errors reported by Magma.", m.internalErrors)
+	counter("magma_worker_restarts_total", "Pool workers and supervised processes restarted after their process exited.", m.restarts)
+	counter("magma_replayed_statements_total", "Statements replayed after a supervised process restarted.", m.replayed)
+	counter("magma_replay_failures_total", "Statements which failed when replayed after a supervised process restarted.", m.replayFailures)
+	hist("magma_command_latency_seconds", "Time from input received to ready for each command.", m.buckets(), &m.latency)
+	hist("magma_queue_wait_seconds", "Time waiting for an idle pool worker.", m.buckets(), &m.queueWait)
+	hist("magma_statement_output_bytes", "Bytes of output from each statement.", m.byteBuckets(), &m.stmtOutput)
+
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countWriter counts the bytes written to w, and records the first error.
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
@@ -0,0 +1,87 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package instrument
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dhowden/magma/proc"
+)
+
+// Slog is an Instrumentation which logs each measurement with a slog.Logger.
+// Commands being started and queue waits are logged at debug level, errors and
+// interrupts as warnings, and everything else at info level.
+type Slog struct {
+	Logger *slog.Logger // If nil, slog.Default() is used
+}
+
+func (l *Slog) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// CommandStarted implements proc.Instrumentation.
+func (l *Slog) CommandStarted(cmd string) {
+	l.log(slog.LevelDebug, "magma command started", slog.String("command", cmd))
+}
+
+// CommandDone implements proc.Instrumentation.
+func (l *Slog) CommandDone(s proc.CommandStats) {
+	l.log(slog.LevelInfo, "magma command done",
+		slog.String("command", s.Command),
+		slog.Duration("latency", s.Latency),
+		slog.Int("statements", s.Statements),
+		slog.Int64("output_bytes", s.OutputBytes),
+		slog.Any("statement_output_bytes", statementOutputBytes(s)),
+	)
+}
+
+// statementOutputBytes returns the bytes of output from each statement in s.
+func statementOutputBytes(s proc.CommandStats) []int64 {
+	n := make([]int64, len(s.PerStatement))
+	for i, st := range s.PerStatement {
+		n[i] = st.OutputBytes
+	}
+	return n
+}
+
+// Interrupted implements proc.Instrumentation.
+func (l *Slog) Interrupted() {
+	l.log(slog.LevelWarn, "magma interrupted")
+}
+
+// ParseError implements proc.Instrumentation.
+func (l *Slog) ParseError() {
+	l.log(slog.LevelWarn, "magma parse error")
+}
+
+// InternalError implements proc.Instrumentation.
+func (l *Slog) InternalError() {
+	l.log(slog.LevelWarn, "magma internal error")
+}
+
+// WorkerRestarted implements proc.Instrumentation.
+func (l *Slog) WorkerRestarted() {
+	l.log(slog.LevelInfo, "magma worker restarted")
+}
+
+// Replayed implements proc.Instrumentation.
+func (l *Slog) Replayed(replayed, failed int) {
+	level := slog.LevelInfo
+	if failed > 0 {
+		level = slog.LevelWarn
+	}
+	l.log(level, "magma statements replayed", slog.Int("replayed", replayed), slog.Int("failed", failed))
+}
+
+// QueueWait implements proc.Instrumentation.
+func (l *Slog) QueueWait(d time.Duration) {
+	l.log(slog.LevelDebug, "magma worker acquired", slog.Duration("wait", d))
+}
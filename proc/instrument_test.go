@@ -0,0 +1,195 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an Instrumentation which records the calls made to it.
+type recorder struct {
+	mu       sync.Mutex
+	started  []string
+	done     []CommandStats
+	calls    map[string]int
+	waits    []time.Duration
+	replayed [][2]int // Replayed and failed counts for each replay
+	doneCh   chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{calls: make(map[string]int), doneCh: make(chan struct{}, 100)}
+}
+
+func (r *recorder) CommandStarted(cmd string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = append(r.started, cmd)
+}
+
+func (r *recorder) CommandDone(s CommandStats) {
+	r.mu.Lock()
+	r.done = append(r.done, s)
+	r.mu.Unlock()
+	r.doneCh <- struct{}{}
+}
+
+func (r *recorder) Interrupted()     { r.call("Interrupted") }
+func (r *recorder) ParseError()      { r.call("ParseError") }
+func (r *recorder) InternalError()   { r.call("InternalError") }
+func (r *recorder) WorkerRestarted() { r.call("WorkerRestarted") }
+
+func (r *recorder) Replayed(replayed, failed int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls["Replayed"]++
+	r.replayed = append(r.replayed, [2]int{replayed, failed})
+}
+
+func (r *recorder) QueueWait(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waits = append(r.waits, d)
+}
+
+func (r *recorder) call(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[name]++
+}
+
+func (r *recorder) count(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[name]
+}
+
+// waitDone waits for CommandDone to be called.
+func (r *recorder) waitDone(t *testing.T) CommandStats {
+	select {
+	case <-r.doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for CommandDone")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done[len(r.done)-1]
+}
+
+func TestInstrumentation(t *testing.T) {
+	r := newRecorder()
+	p := newFakeProcess()
+	p.Instrumentation = r
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	const in = `print "X"^5; 1;`
+	_, err = p.Run(in)
+	checkFatalf(t, "Run() error: %v", err)
+	s := r.waitDone(t)
+	if s.Command != in || s.Statements != 2 || s.OutputBytes != 6 || s.Latency <= 0 {
+		t.Errorf("expected stats for %q with 2 statements and 6 bytes of output, got %+v", in, s)
+	}
+	expected := []StatementStats{
+		{Start: Position{0, 0}, End: Position{0, 12}, OutputBytes: 5},
+		{Start: Position{0, 13}, End: Position{0, 15}, OutputBytes: 1},
+	}
+	if !reflect.DeepEqual(s.PerStatement, expected) {
+		t.Errorf("expected per-statement stats %+v, got %+v", expected, s.PerStatement)
+	}
+	if len(r.started) != 1 || r.started[0] != in {
+		t.Errorf("expected CommandStarted(%q), got %q", in, r.started)
+	}
+
+	for _, in := range []string{"1 +;", "ei();"} {
+		_, err = p.Run(in)
+		checkFatalf(t, "Run() error: %v", err)
+		r.waitDone(t)
+	}
+	if r.count("ParseError") != 1 || r.count("InternalError") != 1 {
+		t.Errorf("expected one parse error and one internal error, got %v", r.calls)
+	}
+
+	o, err := p.Execute("i := 0; while i lt 1 do print i; end while;")
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+	ich, err := p.InterruptExecution()
+	checkFatalf(t, "InterruptExecution() error: %v", err)
+	select {
+	case <-ich:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for interrupt")
+	}
+	r.waitDone(t)
+	if n := r.count("Interrupted"); n != 1 {
+		t.Errorf("expected one interrupt, got %d", n)
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestPoolInstrumentation(t *testing.T) {
+	r := newRecorder()
+	new, processes := poolProcesses(newFakeProcess)
+	pl := &Pool{Size: 1, New: new, Instrumentation: r}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	o, err := pl.Execute("1;")
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+	r.waitDone(t)
+
+	r.mu.Lock()
+	nw := len(r.waits)
+	r.mu.Unlock()
+	if nw != 1 {
+		t.Errorf("expected 1 queue wait, got %d", nw)
+	}
+
+	checkFatalf(t, "Kill() error: %v", processes()[0].Kill())
+	waitForStats(t, pl, func(s PoolStats) bool { return s.Idle == 1 && len(processes()) == 2 })
+	if n := r.count("WorkerRestarted"); n != 1 {
+		t.Errorf("expected 1 worker restart, got %d", n)
+	}
+}
+
+func TestSupervisorInstrumentation(t *testing.T) {
+	r := newRecorder()
+	new, processes := poolProcesses(newTestProcess)
+	s := &Supervisor{New: new, Instrumentation: r}
+	checkFatalf(t, "Start() error: %v", s.Start())
+	defer s.Close()
+
+	s.mu.Lock()
+	s.log = []string{"a := 1;", "print undefined;"}
+	s.mu.Unlock()
+
+	checkFatalf(t, "Kill() error: %v", processes()[0].Kill())
+
+	// Run fails (and restarts the process) if the monitor has not already
+	// restarted the process
+	if _, err := s.Run("print a;"); err != nil {
+		_, err = s.Run("print a;")
+		checkFatalf(t, "Run() error: %v", err)
+	}
+
+	if n := r.count("WorkerRestarted"); n != 1 {
+		t.Errorf("expected 1 restart, got %d", n)
+	}
+	r.mu.Lock()
+	replayed, started := r.replayed, r.started
+	r.mu.Unlock()
+	if expected := [][2]int{{1, 1}}; !reflect.DeepEqual(replayed, expected) {
+		t.Errorf("expected replays %v, got %v", expected, replayed)
+	}
+	// The supervised processes are instrumented
+	if len(started) == 0 || started[len(started)-1] != "print a;" {
+		t.Errorf("expected commands to be instrumented, got %v", started)
+	}
+}
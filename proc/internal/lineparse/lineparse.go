@@ -0,0 +1,98 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lineparse contains helpers for parsing lines of Magma output which
+// are shared by the proc and proc/parse packages.
+package lineparse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Consumer allows for easy handling of lines of output so that lines can be
+// fetched and discarded when properly processed.
+type Consumer struct {
+	source    <-chan string // Source
+	Line      string        // Current line
+	processed bool          // Has the current line been processed?
+}
+
+// NewConsumer returns a Consumer with the given channel as the source of lines
+// to consume.
+func NewConsumer(source <-chan string) *Consumer {
+	return &Consumer{source: source, processed: true}
+}
+
+// NewSliceConsumer returns a Consumer of the given lines.
+func NewSliceConsumer(lines []string) *Consumer {
+	ch := make(chan string, len(lines))
+	for _, l := range lines {
+		ch <- l
+	}
+	close(ch)
+	return NewConsumer(ch)
+}
+
+// FetchNextLine sets Line to the next unprocessed line (with surrounding space
+// removed), returns false if there are no more lines.
+func (c *Consumer) FetchNextLine() bool {
+	if c.processed {
+		x, ok := <-c.source
+		if !ok {
+			return false
+		}
+		c.Line = strings.TrimSpace(x)
+		c.processed = false
+	}
+	return true
+}
+
+// ConsumeLine marks the current line as processed.
+func (c *Consumer) ConsumeLine() {
+	c.processed = true
+}
+
+// Lines fetches all the remaining lines.
+func (c *Consumer) Lines() []string {
+	var lines []string
+	for c.FetchNextLine() {
+		lines = append(lines, c.Line)
+		c.ConsumeLine()
+	}
+	return lines
+}
+
+// MatchCommaRune is a splitting function for commas.
+func MatchCommaRune(r rune) bool {
+	return r == ','
+}
+
+// RowColumnFromFields extracts the line and column where fields are:
+// `[at] line x` and `column y`
+func RowColumnFromFields(fields []string) (row, col int, err error) {
+	if len(fields) != 2 {
+		err = errors.New("expect 2 elements in expansion of line/column location data")
+		return
+	}
+
+	lineSplit := strings.Fields(fields[0])
+	if len(lineSplit) == 0 {
+		err = errors.New("expected line number in location data")
+		return
+	}
+	row, err = strconv.Atoi(lineSplit[len(lineSplit)-1])
+	if err != nil {
+		return
+	}
+
+	colSplit := strings.Fields(fields[1])
+	if len(colSplit) != 2 {
+		err = errors.New("expected column number in location data")
+		return
+	}
+	col, err = strconv.Atoi(colSplit[1])
+	return
+}
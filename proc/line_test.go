@@ -0,0 +1,155 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dhowden/magma/proc/magmatest"
+)
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("X", 100)
+	in := "a\r\n" + long + "\n" + long + "\n\nlast"
+
+	tests := []struct {
+		max      int
+		expected []string
+	}{
+		{0, []string{"a", long, long, "", "last"}},
+		{100, []string{"a", long, long, "", "last"}},
+		{30, []string{"a", long[:30] + fmt.Sprintf(truncatedFormat, 70), long[:30] + fmt.Sprintf(truncatedFormat, 70), "", "last"}},
+	}
+
+	for _, tt := range tests {
+		// Small buffer to force lines to be read in parts
+		r := bufio.NewReaderSize(strings.NewReader(in), 16)
+		var lines []string
+		for {
+			l, err := readLine(r, tt.max)
+			if err == io.EOF {
+				break
+			}
+			checkFatalf(t, "readLine() error: %v", err)
+			if l.truncated != (len(l.data) > tt.max && tt.max > 0) {
+				t.Errorf("readLine(%d): unexpected truncated = %v for %q", tt.max, l.truncated, l.data)
+			}
+			lines = append(lines, string(l.data))
+		}
+		if !reflect.DeepEqual(lines, tt.expected) {
+			t.Errorf("readLine(%d): expected %q, got %q", tt.max, tt.expected, lines)
+		}
+	}
+}
+
+// runLongLine runs a command giving a single line of n bytes on p, and
+// returns the resulting lines.
+func runLongLine(t *testing.T, p *Process, n int) []*Line {
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	res, err := p.Run(fmt.Sprintf(`print "X"^%d;`, n))
+	checkFatalf(t, "Run() error: %v", err)
+
+	var lines []*Line
+	for _, st := range res.Statements {
+		lines = append(lines, st.Lines[TagOutput]...)
+	}
+
+	// The session continues after a long line
+	res, err = p.Run("1;")
+	checkFatalf(t, "Run() error: %v", err)
+	if text := res.Text(); text != "1" {
+		t.Errorf("expected output %q, got %q", "1", text)
+	}
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+	return lines
+}
+
+func newLongLinesProcess() *Process {
+	cmd, env := magmatest.Command(fakeLongLinesName)
+	return &Process{Command: cmd, Env: env}
+}
+
+func TestLongLine(t *testing.T) {
+	const n = 4 << 20
+	lines := runLongLine(t, newLongLinesProcess(), n)
+	if len(lines) != 1 || len(lines[0].Data) != n || lines[0].Truncated {
+		t.Errorf("expected a single line of %d bytes", n)
+	}
+}
+
+func TestLongLineTruncated(t *testing.T) {
+	p := newLongLinesProcess()
+	p.MaxLineLength = 1 << 20
+	lines := runLongLine(t, p, 4<<20)
+
+	if len(lines) != 1 || !lines[0].Truncated {
+		t.Fatalf("expected a single truncated line")
+	}
+	// The tag is included in the line length
+	tag := len("\x81OUT 0\x81")
+	marker := fmt.Sprintf(truncatedFormat, (3<<20)+tag)
+	if data := lines[0].Data; data != strings.Repeat("X", (1<<20)-tag)+marker {
+		t.Errorf("expected %d bytes of data ending %q, got %d bytes ending %q",
+			(1<<20)-tag, marker, len(data), data[len(data)-len(marker):])
+	}
+}
+
+func TestLongLineChunks(t *testing.T) {
+	p := newLongLinesProcess()
+	p.LineChunkSize = 1000
+	lines := runLongLine(t, p, 2500)
+
+	var sizes []int
+	for i, l := range lines {
+		sizes = append(sizes, len(l.Data))
+		if l.Continuation != (i > 0) {
+			t.Errorf("expected line %d to have Continuation %v", i, i > 0)
+		}
+	}
+	if expected := []int{1000, 1000, 500}; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected chunks of %v bytes, got %v", expected, sizes)
+	}
+}
+
+func TestLineTruncatedFromTranscript(t *testing.T) {
+	const tr = `< "\x81RDY 0 0 0 0 0"
+> "print \"X... [5 bytes truncated]\";"
+> "\x04"
+< "\x81IR"
+< "\x81RUN 1 0 0 0 0 35"
+< "\x81OUT 0\x81X... [5 bytes truncated]"
+~ "\x81OUT 0\x81XX... [3 bytes truncated]"
+< "\x81RDY 0 0 0 0 0"
+`
+	var truncated []bool
+	first := true
+	err := Replay(strings.NewReader(tr), func(o *Output) error {
+		if first {
+			first = false
+			return nil
+		}
+		for x := range o.Output() {
+			if x, ok := x.(*Line); ok {
+				truncated = append(truncated, x.Truncated)
+			}
+		}
+		return nil
+	})
+	checkFatalf(t, "Replay() error: %v", err)
+
+	// Only lines which were truncated when read are marked, whatever they contain
+	if expected := []bool{false, true}; !reflect.DeepEqual(truncated, expected) {
+		t.Errorf("expected Truncated %v, got %v", expected, truncated)
+	}
+}
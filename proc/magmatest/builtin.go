@@ -0,0 +1,154 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magmatest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	identExpr   = `[A-Za-z_][A-Za-z0-9_]*`
+	literalExpr = `-?[0-9]+|"(?:[^"\\]|\\.)*"`
+	valueExpr   = identExpr + `|` + literalExpr
+)
+
+var (
+	quitRegexp       = regexp.MustCompile(`^(?:quit|exit)\s*;$`)
+	assignRegexp     = regexp.MustCompile(`^(` + identExpr + `)\s*(:=|cat:=)\s*(` + valueExpr + `)\s*;$`)
+	printRegexp      = regexp.MustCompile(`^(?:print\s+)?(` + valueExpr + `)\s*;$`)
+	printfRegexp     = regexp.MustCompile(`^printf\s+("(?:[^"\\]|\\.)*")\s*;$`)
+	indentPushRegexp = regexp.MustCompile(`^IndentPush\(\s*\)\s*;$`)
+	indentPopRegexp  = regexp.MustCompile(`^IndentPop\(\s*\)\s*;$`)
+	readRegexp       = regexp.MustCompile(`^(readi?)\s+(` + identExpr + `)\s*(?:,\s*(` + valueExpr + `))?\s*;$`)
+	setSeedRegexp    = regexp.MustCompile(`^SetSeed\(\s*([0-9]+)\s*(?:,\s*([0-9]+)\s*)?\)\s*;$`)
+	randomRegexp     = regexp.MustCompile(`^(?:print\s+)?Random\(\s*([0-9]+)\s*\)\s*;$`)
+)
+
+// builtin runs statements which have no registered handler.
+func builtin(st *Stmt) {
+	switch src := st.Source; {
+	case quitRegexp.MatchString(src):
+		st.Quit()
+
+	case indentPushRegexp.MatchString(src):
+		st.IndentPush()
+
+	case indentPopRegexp.MatchString(src):
+		st.IndentPop()
+
+	default:
+		if m := assignRegexp.FindStringSubmatch(src); m != nil {
+			v, ok := st.value(m[3])
+			if !ok {
+				return
+			}
+			if m[2] == "cat:=" {
+				v = st.s.vars[m[1]] + v
+			}
+			st.Set(m[1], v)
+			return
+		}
+
+		if m := printRegexp.FindStringSubmatch(src); m != nil {
+			if v, ok := st.value(m[1]); ok {
+				st.Print(v)
+			}
+			return
+		}
+
+		if m := printfRegexp.FindStringSubmatch(src); m != nil {
+			if v, ok := st.value(m[1]); ok {
+				st.Printf("%s", v)
+			}
+			return
+		}
+
+		if m := setSeedRegexp.FindStringSubmatch(src); m != nil {
+			seed, err := strconv.ParseUint(m[1], 10, 32)
+			if err != nil {
+				st.RuntimeError("Runtime error in 'SetSeed': Argument 1 should be a small integer")
+				return
+			}
+			var step uint64
+			if m[2] != "" {
+				step, _ = strconv.ParseUint(m[2], 10, 64)
+			}
+			st.SetSeed(uint(seed), step)
+			return
+		}
+
+		if m := randomRegexp.FindStringSubmatch(src); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n == 0 {
+				st.RuntimeError("Runtime error in 'Random': Argument 1 should be positive")
+				return
+			}
+			st.Print(strconv.Itoa(st.Random(n)))
+			return
+		}
+
+		if m := readRegexp.FindStringSubmatch(src); m != nil {
+			prompt := ""
+			if m[3] != "" {
+				var ok bool
+				if prompt, ok = st.value(m[3]); !ok {
+					return
+				}
+			}
+			if m[1] == "readi" {
+				n, err := st.ReadInt(prompt)
+				if err == nil {
+					st.Set(m[2], strconv.Itoa(n))
+				}
+				return
+			}
+			if l, err := st.Read(prompt); err == nil {
+				st.Set(m[2], l)
+			}
+			return
+		}
+	}
+}
+
+// value evaluates a literal or identifier, reporting a user error (and
+// returning false) if it cannot be evaluated.
+func (st *Stmt) value(expr string) (string, bool) {
+	if strings.HasPrefix(expr, `"`) {
+		return unquote(expr[1 : len(expr)-1]), true
+	}
+	if _, err := strconv.Atoi(expr); err == nil {
+		return expr, true
+	}
+	if v, ok := st.Get(expr); ok {
+		return v, true
+	}
+	st.UserError(fmt.Sprintf("User error: Identifier '%v' has not been declared or assigned", expr))
+	return "", false
+}
+
+// unquote interprets the escape sequences in a Magma string literal.
+func unquote(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
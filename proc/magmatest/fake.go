@@ -0,0 +1,412 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package magmatest provides a scriptable fake Magma process which speaks the
+// tagged protocol of `magma -x`, so that code using package proc can be tested
+// without a licensed Magma installation.
+//
+// A Fake can be served over any io.Reader/io.Writer pair (see Fake.Serve), or
+// run as a child process in place of Magma by re-executing the test binary
+// (see Register, Main and Command).
+package magmatest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Special characters used in communication
+const (
+	newTagChar     byte = 129 // Prefixes every tag line
+	runCommandChar byte = 4   // Run command (^D)
+)
+
+// lineLength is the size of the Magma output buffer: longer lines are split
+// and the remainder marked as a continuation (see Fake.LineLength).
+const lineLength = 1024
+
+// Handler is a function which runs a statement in a fake Magma session.
+type Handler func(s *Stmt)
+
+type handler struct {
+	stmt     string
+	re       *regexp.Regexp
+	h        Handler
+	parseErr []string // Parse error messages (if h is nil)
+}
+
+// Fake is a scriptable fake Magma.  Statements are matched against the
+// handlers registered with Handle and HandleRegexp (in order).  Statements
+// without a handler are run by a small set of builtins which understand
+// literal assignment, print, printf, IndentPush/IndentPop, read, readi,
+// SetSeed, Random and quit.  Anything else runs successfully with no output.
+//
+// A Fake must not be modified once it is serving a session.
+type Fake struct {
+	Startup []string // Output lines given before the first RDY tag
+	Banner  []string // Untagged lines written before any other output (as without -x)
+	Seed    uint     // Initial random seed reported in RUN tags
+
+	// Stderr is written to stderr before the session starts when the Fake
+	// is run by Main.
+	Stderr string
+
+	// Exit, if non-zero, ends the session after the Startup output (before
+	// the first RDY tag), and is the exit status used by Main.
+	Exit int
+
+	// IgnoreInterrupts makes Main ignore interrupt signals (as a Magma process
+	// which has hung would).
+	IgnoreInterrupts bool
+
+	// LineLength is the length at which output lines are split.  If zero, the
+	// size of the Magma output buffer (1024) is used, and if negative then
+	// lines are never split.
+	LineLength int
+
+	handlers []handler
+}
+
+// Handle registers a handler for statements with the given source (including
+// the terminating semicolon).  Leading and trailing space is ignored.
+func (f *Fake) Handle(stmt string, h Handler) {
+	f.handlers = append(f.handlers, handler{stmt: strings.TrimSpace(stmt), h: h})
+}
+
+// HandleParseError registers the given statement source as one which fails to
+// parse (giving an ERP tag), followed by any given user error lines.
+func (f *Fake) HandleParseError(stmt string, msg ...string) {
+	f.handlers = append(f.handlers, handler{stmt: strings.TrimSpace(stmt), parseErr: msg})
+}
+
+// HandleRegexp registers a handler for statements whose entire source matches
+// the given regular expression.  Submatches are given in Stmt.Match.
+func (f *Fake) HandleRegexp(re *regexp.Regexp, h Handler) {
+	f.handlers = append(f.handlers, handler{re: re, h: h})
+}
+
+func (f *Fake) lookup(st *Stmt) handler {
+	for _, x := range f.handlers {
+		if x.re != nil {
+			if m := x.re.FindStringSubmatch(st.Source); m != nil && m[0] == st.Source {
+				st.Match = m
+				return x
+			}
+			continue
+		}
+		if x.stmt == st.Source {
+			return x
+		}
+	}
+	return handler{h: builtin}
+}
+
+// errQuit is used internally to end a session.
+var errQuit = errors.New("magmatest: quit")
+
+// errExit is used internally to end a session which has Fake.Exit set.
+var errExit = errors.New("magmatest: exit")
+
+// Serve runs a fake Magma session, reading input from r and writing tagged
+// output to w as `magma -x` does on stdin and stdout.  Each value received
+// on intr acts as an interrupt signal (SIGINT); intr may be nil.
+//
+// Serve returns nil when the session quits or r reaches EOF.  If a second
+// interrupt arrives before a statement has stopped, then the session quits
+// immediately (as Magma does), abandoning the handler goroutine.
+func (f *Fake) Serve(r io.Reader, w io.Writer, intr <-chan struct{}) error {
+	s := newSession(f, r, w)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.run()
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			s.close()
+			if err == errQuit || err == errExit || err == io.EOF {
+				return nil
+			}
+			return err
+
+		case _, ok := <-intr:
+			if !ok {
+				intr = nil
+				continue
+			}
+			if s.interrupt() {
+				s.close()
+				return nil
+			}
+		}
+	}
+}
+
+type session struct {
+	f *Fake
+
+	in      chan []byte // Chunks read from input
+	inErr   error       // Error which ended input (set before in is closed)
+	pending []byte      // Input read but not yet consumed
+	stop    chan struct{}
+
+	mu      sync.Mutex // Guards w and running
+	w       io.Writer
+	running *Stmt
+
+	vars   map[string]string
+	seed   uint
+	step   uint64
+	indent int
+
+	// Output line buffer
+	line       []byte
+	lineIndent int
+	cont       bool
+}
+
+func newSession(f *Fake, r io.Reader, w io.Writer) *session {
+	s := &session{
+		f:    f,
+		in:   make(chan []byte),
+		stop: make(chan struct{}),
+		w:    w,
+		vars: make(map[string]string),
+		seed: f.Seed,
+	}
+	go s.readInput(r)
+	return s
+}
+
+func (s *session) close() {
+	close(s.stop)
+}
+
+func (s *session) readInput(r io.Reader) {
+	for {
+		b := make([]byte, 4096)
+		n, err := r.Read(b)
+		if n > 0 {
+			select {
+			case s.in <- b[:n]:
+			case <-s.stop:
+				return
+			}
+		}
+		if err != nil {
+			s.inErr = err
+			close(s.in)
+			return
+		}
+	}
+}
+
+// readUntil returns the input up to (and not including) the next delim byte.
+// If abort is closed first then errInterrupted is returned.
+func (s *session) readUntil(delim byte, abort <-chan struct{}) (string, error) {
+	for {
+		if i := bytes.IndexByte(s.pending, delim); i >= 0 {
+			x := string(s.pending[:i])
+			s.pending = s.pending[i+1:]
+			return x, nil
+		}
+		select {
+		case b, ok := <-s.in:
+			if !ok {
+				return "", s.inErr
+			}
+			s.pending = append(s.pending, b...)
+		case <-abort:
+			return "", errInterrupted
+		}
+	}
+}
+
+// interrupt handles an interrupt signal, returning true if the session should
+// end.
+func (s *session) interrupt() bool {
+	s.mu.Lock()
+	st := s.running
+	first := st != nil && st.interrupt()
+	s.mu.Unlock()
+
+	switch {
+	case st == nil:
+		s.tag("INT", nil, "")
+		return false
+	case first:
+		return false
+	}
+	s.tag("QUIT", nil, "")
+	return true
+}
+
+func (s *session) setRunning(st *Stmt) {
+	s.mu.Lock()
+	s.running = st
+	s.mu.Unlock()
+}
+
+func (s *session) run() error {
+	for _, l := range s.f.Banner {
+		s.mu.Lock()
+		io.WriteString(s.w, l+"\n")
+		s.mu.Unlock()
+	}
+	for _, l := range s.f.Startup {
+		s.printf(l + "\n")
+	}
+	s.flush()
+	if s.f.Exit != 0 {
+		return errExit
+	}
+	s.ready()
+
+	for {
+		input, err := s.readUntil(runCommandChar, nil)
+		if err != nil {
+			return err
+		}
+		s.tag("IR", nil, "")
+		if err := s.execute(input); err != nil {
+			return err
+		}
+		s.flush()
+		s.ready()
+	}
+}
+
+func (s *session) ready() {
+	s.tag("RDY", []string{"0", "0", "0", "0", "0"}, "")
+}
+
+func (s *session) execute(input string) error {
+	for _, x := range splitStatements(input) {
+		st := &Stmt{
+			Source:      strings.TrimSpace(x.source),
+			Start:       x.start,
+			End:         x.end,
+			s:           s,
+			interrupted: make(chan struct{}),
+		}
+
+		if !x.complete {
+			st.parseError()
+			s.tag("ENE", nil, "")
+			return nil
+		}
+
+		h := s.f.lookup(st)
+		if h.h == nil {
+			st.parseError(h.parseErr...)
+			continue
+		}
+
+		s.setRunning(st)
+		err := st.run(h.h)
+		s.setRunning(nil)
+		if err != nil {
+			return err
+		}
+		if st.wasInterrupted() {
+			s.flush()
+			s.tag("INT", nil, "")
+			return nil
+		}
+	}
+	return nil
+}
+
+// tag writes a tag line with the given fields and data.
+func (s *session) tag(name string, fields []string, data string) {
+	var b bytes.Buffer
+	b.WriteByte(newTagChar)
+	b.WriteString(name)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f)
+	}
+	if data != "" || name == "OUT" || name == "LST" || name == "SIG" || name == "TB" || name == "EPO" {
+		b.WriteByte(newTagChar)
+		b.WriteString(data)
+	}
+	b.WriteByte('\n')
+
+	s.mu.Lock()
+	s.w.Write(b.Bytes())
+	s.mu.Unlock()
+}
+
+// printf appends text to the output line buffer, writing out complete lines.
+func (s *session) printf(text string) {
+	for {
+		i := strings.IndexByte(text, '\n')
+		part := text
+		if i >= 0 {
+			part = text[:i]
+		}
+
+		if len(s.line) == 0 && !s.cont {
+			s.lineIndent = s.indent
+		}
+		s.line = append(s.line, part...)
+		for n := s.lineLength(); n > 0 && len(s.line) > n; {
+			s.writeLine(s.line[:n])
+			s.line = s.line[n:]
+			s.cont = true
+		}
+
+		if i < 0 {
+			return
+		}
+		s.writeLine(s.line)
+		s.line = s.line[:0]
+		s.cont = false
+		text = text[i+1:]
+	}
+}
+
+// lineLength returns the length at which output lines are split (zero if
+// they are not split).
+func (s *session) lineLength() int {
+	switch {
+	case s.f.LineLength < 0:
+		return 0
+	case s.f.LineLength == 0:
+		return lineLength
+	}
+	return s.f.LineLength
+}
+
+func (s *session) writeLine(l []byte) {
+	field := strconv.Itoa(s.lineIndent)
+	if s.cont {
+		field = "C"
+	}
+	s.tag("OUT", []string{field}, string(l))
+}
+
+// flush writes out any partial line in the output buffer.
+func (s *session) flush() {
+	if len(s.line) > 0 {
+		s.writeLine(s.line)
+	}
+	s.line = s.line[:0]
+	s.cont = false
+}
+
+// lines writes the given text as a sequence of tag lines.
+func (s *session) lines(name string, text string) {
+	s.flush()
+	for _, l := range strings.Split(text, "\n") {
+		s.tag(name, []string{"0"}, l)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magmatest
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeSession runs the fake f over pipes, and provides helpers for sending
+// input and reading tag lines.
+type pipeSession struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *bufio.Scanner
+	intr chan struct{}
+	done chan error
+}
+
+func newPipeSession(t *testing.T, f *Fake) *pipeSession {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &pipeSession{
+		t:    t,
+		in:   inW,
+		out:  bufio.NewScanner(outR),
+		intr: make(chan struct{}),
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := f.Serve(inR, outW, s.intr)
+		outW.Close()
+		s.done <- err
+	}()
+	return s
+}
+
+// readable returns a tag line with the tag prefix char replaced by '|'
+func readable(l string) string {
+	return strings.Replace(l, string([]byte{newTagChar}), "|", -1)
+}
+
+func (s *pipeSession) send(input string) {
+	go s.in.Write(append([]byte(input), runCommandChar))
+}
+
+// expect reads tag lines and compares them with those given (using '|' in
+// place of the tag prefix char).
+func (s *pipeSession) expect(lines ...string) {
+	for _, want := range lines {
+		if !s.out.Scan() {
+			s.t.Fatalf("expected %q, but output ended: %v", want, s.out.Err())
+		}
+		if got := readable(s.out.Text()); got != want {
+			s.t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func (s *pipeSession) wait() {
+	select {
+	case err := <-s.done:
+		if err != nil {
+			s.t.Errorf("unexpected Serve() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		s.t.Errorf("Serve() did not return")
+	}
+}
+
+func TestFakeStartup(t *testing.T) {
+	s := newPipeSession(t, &Fake{Startup: []string{"Magma V2.20-10"}})
+	s.expect("|OUT 0|Magma V2.20-10", "|RDY 0 0 0 0 0")
+	s.in.Close()
+	s.wait()
+}
+
+func TestFakeExit(t *testing.T) {
+	s := newPipeSession(t, &Fake{Banner: []string{"Magma V2.20-10"}, Startup: []string{"Bye"}, Exit: 1})
+	s.expect("Magma V2.20-10", "|OUT 0|Bye")
+	if s.out.Scan() {
+		t.Errorf("expected output to end, got %q", readable(s.out.Text()))
+	}
+	s.wait()
+}
+
+func TestFakeBuiltins(t *testing.T) {
+	s := newPipeSession(t, &Fake{Seed: 42})
+	s.expect("|RDY 0 0 0 0 0")
+
+	s.send(`a := 1; print a;` + "\n" + `printf "X"; "Y";`)
+	s.expect(
+		"|IR",
+		"|RUN 42 0 0 0 0 7",
+		"|RUN 42 0 0 8 0 16",
+		"|OUT 0|1",
+		"|RUN 42 0 1 0 1 11",
+		"|RUN 42 0 1 12 1 16",
+		"|OUT 0|XY",
+		"|RDY 0 0 0 0 0",
+	)
+
+	s.send(`print b;`)
+	s.expect(
+		"|IR",
+		"|RUN 42 0 0 0 0 8",
+		"|EU 0|User error: Identifier 'b' has not been declared or assigned",
+		"|RDY 0 0 0 0 0",
+	)
+
+	s.send(`quit;`)
+	s.expect("|IR", "|RUN 42 0 0 0 0 5", "|QUIT")
+	s.wait()
+}
+
+func TestFakeContinuation(t *testing.T) {
+	f := &Fake{}
+	f.Handle("p();", func(s *Stmt) {
+		s.IndentPush()
+		s.Print(strings.Repeat("X", lineLength+1))
+		s.IndentPop()
+	})
+
+	s := newPipeSession(t, f)
+	s.expect("|RDY 0 0 0 0 0")
+	s.send("p();")
+	s.expect(
+		"|IR",
+		"|RUN 0 0 0 0 0 4",
+		"|OUT 1|"+strings.Repeat("X", lineLength),
+		"|OUT C|X",
+		"|RDY 0 0 0 0 0",
+	)
+	s.in.Close()
+	s.wait()
+}
+
+func TestFakeParseError(t *testing.T) {
+	f := &Fake{}
+	f.HandleParseError("1 +;", "User error: bad syntax")
+
+	s := newPipeSession(t, f)
+	s.expect("|RDY 0 0 0 0 0")
+	s.send("1 +; 2 +")
+	s.expect(
+		"|IR",
+		"|ERP 0 0 0 4",
+		"|EU 0|User error: bad syntax",
+		"|ERP 0 5 0 8",
+		"|ENE",
+		"|RDY 0 0 0 0 0",
+	)
+	s.in.Close()
+	s.wait()
+}
+
+func TestFakeSeed(t *testing.T) {
+	s := newPipeSession(t, &Fake{Seed: 42})
+	s.expect("|RDY 0 0 0 0 0")
+
+	s.send(`SetSeed(7, 3); Random(100); Random(100);`)
+	s.expect(
+		"|IR",
+		"|RUN 42 0 0 0 0 14",
+		"|RUN 7 3 0 15 0 27",
+		"|OUT 0|72",
+		"|RUN 7 4 0 28 0 40",
+		"|OUT 0|52",
+		"|RDY 0 0 0 0 0",
+	)
+	s.in.Close()
+	s.wait()
+}
+
+func TestFakeRead(t *testing.T) {
+	s := newPipeSession(t, &Fake{})
+	s.expect("|RDY 0 0 0 0 0")
+
+	s.send(`readi x, "x\n:"; x;`)
+	s.expect(
+		"|IR",
+		"|RUN 0 0 0 0 0 16",
+		"|RDI_PR 0|x",
+		"|RDI_PR 0|:",
+		"|RDI_IN",
+	)
+	go s.in.Write([]byte("y\n"))
+	s.expect(
+		"|RDI_ER 0|Bad integer input, please try again",
+		"|RDI_PR 0|x",
+		"|RDI_PR 0|:",
+		"|RDI_IN",
+	)
+	go s.in.Write([]byte("12\n"))
+	s.expect(
+		"|RUN 0 0 0 17 0 19",
+		"|OUT 0|12",
+		"|RDY 0 0 0 0 0",
+	)
+	s.in.Close()
+	s.wait()
+}
+
+func TestFakeDebug(t *testing.T) {
+	f := &Fake{}
+	f.Handle("f();", func(st *Stmt) {
+		st.Debug(func(st *Stmt, cmd string) bool {
+			if cmd == "continue" {
+				return false
+			}
+			st.Printf("%v\n", cmd)
+			return true
+		})
+		st.Print("done")
+	})
+
+	s := newPipeSession(t, f)
+	s.expect("|RDY 0 0 0 0 0")
+
+	s.send("f();")
+	s.expect("|IR", "|RUN 0 0 0 0 0 4", "|DRDY")
+	s.send("bt")
+	s.expect("|OUT 0|bt", "|DRDY")
+	s.send("continue")
+	s.expect("|OUT 0|done", "|RDY 0 0 0 0 0")
+	s.in.Close()
+	s.wait()
+}
+
+func TestFakeInterrupt(t *testing.T) {
+	f := &Fake{}
+	f.Handle("while true do end while;", func(s *Stmt) {
+		<-s.Interrupted()
+	})
+
+	s := newPipeSession(t, f)
+	s.expect("|RDY 0 0 0 0 0")
+
+	// Interrupt whilst idle
+	s.intr <- struct{}{}
+	s.expect("|INT")
+
+	// Interrupt whilst running
+	s.send("while true do end while; print 1;")
+	s.expect("|IR", "|RUN 0 0 0 0 0 24")
+	s.intr <- struct{}{}
+	s.expect("|INT", "|RDY 0 0 0 0 0")
+	s.in.Close()
+	s.wait()
+}
+
+func TestFakeDoubleInterrupt(t *testing.T) {
+	f := &Fake{}
+	f.Handle("Hang();", func(s *Stmt) {
+		select {}
+	})
+
+	s := newPipeSession(t, f)
+	s.expect("|RDY 0 0 0 0 0")
+	s.send("Hang();")
+	s.expect("|IR", "|RUN 0 0 0 0 0 7")
+	s.intr <- struct{}{}
+	s.intr <- struct{}{}
+	s.expect("|QUIT")
+	s.wait()
+}
@@ -0,0 +1,83 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magmatest
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// EnvName is the environment variable which selects the registered Fake to
+// run when the test binary is started by Command.
+const EnvName = "MAGMATEST_FAKE"
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Fake)
+)
+
+// Register makes a Fake available to Command under the given name.  Fakes
+// must be registered before Main is called (typically in TestMain).
+func Register(name string, f *Fake) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("magmatest: Register called twice for " + name)
+	}
+	registry[name] = f
+}
+
+// Main runs the Fake selected by the environment (see Command) on stdin and
+// stdout, and then exits.  If no Fake has been selected then Main returns
+// immediately.  It should be called from TestMain before m.Run:
+//
+//	func TestMain(m *testing.M) {
+//		magmatest.Register("basic", &magmatest.Fake{})
+//		magmatest.Main()
+//		os.Exit(m.Run())
+//	}
+func Main() {
+	name := os.Getenv(EnvName)
+	if name == "" {
+		return
+	}
+
+	registryMu.Lock()
+	f, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		fmt.Fprintf(os.Stderr, "magmatest: no fake registered with name %q\n", name)
+		os.Exit(2)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	intr := make(chan struct{})
+	go func() {
+		for _ = range sig {
+			if !f.IgnoreInterrupts {
+				intr <- struct{}{}
+			}
+		}
+	}()
+
+	if f.Stderr != "" {
+		fmt.Fprint(os.Stderr, f.Stderr)
+	}
+	if err := f.Serve(os.Stdin, os.Stdout, intr); err != nil {
+		fmt.Fprintf(os.Stderr, "magmatest: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(f.Exit)
+}
+
+// Command returns the command and environment which start the test binary as
+// the Fake registered with the given name.  Suitable for use as the Command
+// and Env of a proc.Process.  Any arguments passed to the command are ignored.
+func Command(name string) (command string, env []string) {
+	return os.Args[0], append(os.Environ(), EnvName+"="+name)
+}
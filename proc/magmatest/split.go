@@ -0,0 +1,183 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magmatest
+
+import "strings"
+
+// Position is a (zero-based) row and byte column in the input, as reported
+// by Magma in RUN, ERP and POS tags.
+type Position struct {
+	Row, Column int
+}
+
+// statement is a chunk of input which Magma would run as a single statement.
+type statement struct {
+	source     string
+	start, end Position
+	complete   bool // false if the input finished before the statement did
+}
+
+// Keywords which open a block closed by `end <keyword>` (or `until` for repeat).
+var blockKeywords = map[string]bool{
+	"while":     true,
+	"for":       true,
+	"if":        true,
+	"case":      true,
+	"function":  true,
+	"procedure": true,
+	"intrinsic": true,
+	"repeat":    true,
+	"try":       true,
+}
+
+// splitter breaks input into statements in the same way as the Magma parser
+// (at least well enough to match RUN and ERP tag positions for simple input).
+type splitter struct {
+	input    string
+	off      int
+	pos      Position
+	depth    int
+	start    int
+	startPos Position
+	started  bool
+	stmts    []statement
+}
+
+func splitStatements(input string) []statement {
+	s := &splitter{input: input}
+	s.run()
+	return s.stmts
+}
+
+func (s *splitter) peek(i int) byte {
+	if s.off+i < len(s.input) {
+		return s.input[s.off+i]
+	}
+	return 0
+}
+
+func (s *splitter) advance(n int) {
+	for i := 0; i < n && s.off < len(s.input); i++ {
+		if s.input[s.off] == '\n' {
+			s.pos.Row++
+			s.pos.Column = 0
+		} else {
+			s.pos.Column++
+		}
+		s.off++
+	}
+}
+
+func (s *splitter) mark() {
+	if !s.started {
+		s.started = true
+		s.start = s.off
+		s.startPos = s.pos
+	}
+}
+
+func (s *splitter) emit(complete bool) {
+	s.stmts = append(s.stmts, statement{
+		source:   s.input[s.start:s.off],
+		start:    s.startPos,
+		end:      s.pos,
+		complete: complete,
+	})
+	s.started = false
+	s.depth = 0
+}
+
+func (s *splitter) run() {
+	for s.off < len(s.input) {
+		c := s.peek(0)
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			s.advance(1)
+
+		case c == '/' && s.peek(1) == '*':
+			end := strings.Index(s.input[s.off+2:], "*/")
+			if end < 0 {
+				s.advance(len(s.input) - s.off)
+			} else {
+				s.advance(end + 4)
+			}
+
+		case c == '/' && s.peek(1) == '/':
+			end := strings.IndexByte(s.input[s.off:], '\n')
+			if end < 0 {
+				end = len(s.input) - s.off
+			}
+			s.advance(end)
+
+		case c == '"':
+			s.mark()
+			s.advance(1)
+			for s.off < len(s.input) && s.peek(0) != '"' {
+				if s.peek(0) == '\\' {
+					s.advance(1)
+				}
+				s.advance(1)
+			}
+			s.advance(1)
+
+		case c == ';':
+			s.mark()
+			s.advance(1)
+			if s.depth <= 0 {
+				s.emit(true)
+			}
+
+		case isIdentStart(c):
+			s.mark()
+			word := s.word()
+			s.advance(len(word))
+			switch {
+			case word == "end":
+				s.skipSpace()
+				s.advance(len(s.word()))
+				s.depth--
+			case word == "until":
+				s.depth--
+			case word == "case":
+				s.skipSpace()
+				if s.peek(0) != '<' {
+					s.depth++
+				}
+			case blockKeywords[word]:
+				s.depth++
+			}
+
+		default:
+			s.mark()
+			s.advance(1)
+		}
+	}
+	if s.started {
+		s.emit(false)
+	}
+}
+
+func (s *splitter) skipSpace() {
+	for s.off < len(s.input) {
+		switch s.peek(0) {
+		case ' ', '\t', '\n', '\r':
+			s.advance(1)
+		default:
+			return
+		}
+	}
+}
+
+func (s *splitter) word() string {
+	i := s.off
+	for i < len(s.input) && (isIdentStart(s.input[i]) || (s.input[i] >= '0' && s.input[i] <= '9')) {
+		i++
+	}
+	return s.input[s.off:i]
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,56 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magmatest
+
+import "testing"
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		in  string
+		out []statement
+	}{
+		{"/* Comment! */", nil},
+		{
+			"a := 1; print a;",
+			[]statement{
+				{"a := 1;", Position{0, 0}, Position{0, 7}, true},
+				{"print a;", Position{0, 8}, Position{0, 16}, true},
+			},
+		},
+		{
+			"i := 0; while i lt 1 do print i; end while;",
+			[]statement{
+				{"i := 0;", Position{0, 0}, Position{0, 7}, true},
+				{"while i lt 1 do print i; end while;", Position{0, 8}, Position{0, 43}, true},
+			},
+		},
+		{
+			"f := function(x)\n  if x then return \";\"; end if;\n  return case<x | true: 1, default: 2>;\nend function;\n// done;\nf(1",
+			[]statement{
+				{"f := function(x)\n  if x then return \";\"; end if;\n  return case<x | true: 1, default: 2>;\nend function;", Position{0, 0}, Position{3, 13}, true},
+				{"f(1", Position{5, 0}, Position{5, 3}, false},
+			},
+		},
+		{
+			"repeat x := 1; until true;",
+			[]statement{
+				{"repeat x := 1; until true;", Position{0, 0}, Position{0, 26}, true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := splitStatements(tt.in)
+		if len(got) != len(tt.out) {
+			t.Errorf("splitStatements(%q) = %v, expected %v", tt.in, got, tt.out)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.out[i] {
+				t.Errorf("splitStatements(%q)[%d] = %v, expected %v", tt.in, i, got[i], tt.out[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package magmatest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errInterrupted is returned when a read is aborted by an interrupt.
+var errInterrupted = errors.New("magmatest: interrupted")
+
+// Stmt represents a statement being run by a fake Magma session, and provides
+// methods which produce the corresponding tagged output.
+type Stmt struct {
+	Source     string   // Statement source (including the terminating semicolon)
+	Start, End Position // Position of the statement in the input
+	Match      []string // Submatches (if matched by a HandleRegexp handler)
+
+	s    *session
+	quit bool
+
+	mu          sync.Mutex
+	interrupted chan struct{}
+	intr        bool
+}
+
+func (st *Stmt) run(h Handler) error {
+	st.begin()
+	h(st)
+	if st.quit {
+		st.s.flush()
+		st.s.tag("QUIT", nil, "")
+		return errQuit
+	}
+	return nil
+}
+
+// interrupt marks the statement as interrupted, returning false if it
+// had already been interrupted.
+func (st *Stmt) interrupt() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.intr {
+		return false
+	}
+	st.intr = true
+	close(st.interrupted)
+	return true
+}
+
+func (st *Stmt) wasInterrupted() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.intr
+}
+
+// Interrupted returns a channel which is closed when the session is
+// interrupted whilst running this statement.  Long running handlers should
+// return promptly once it is closed.  If a second interrupt arrives before
+// the handler returns, then the session quits.
+func (st *Stmt) Interrupted() <-chan struct{} { return st.interrupted }
+
+// positionFields returns the tag fields for the statement position.
+func (st *Stmt) positionFields() []string {
+	return []string{
+		strconv.Itoa(st.Start.Row), strconv.Itoa(st.Start.Column),
+		strconv.Itoa(st.End.Row), strconv.Itoa(st.End.Column),
+	}
+}
+
+// begin writes the RUN tag for the statement.
+func (st *Stmt) begin() {
+	fields := []string{strconv.FormatUint(uint64(st.s.seed), 10), strconv.FormatUint(st.s.step, 10)}
+	st.s.tag("RUN", append(fields, st.positionFields()...), "")
+}
+
+// parseError reports that the statement could not be parsed (an ERP tag),
+// followed by any given user error lines.
+func (st *Stmt) parseError(msg ...string) {
+	st.s.tag("ERP", st.positionFields(), "")
+	for _, m := range msg {
+		st.s.lines("EU", m)
+	}
+}
+
+// Printf formats according to the format specifier and adds the result to
+// the output, as with the Magma printf statement (i.e. lines are only written
+// once complete).
+func (st *Stmt) Printf(format string, a ...interface{}) {
+	st.s.printf(fmt.Sprintf(format, a...))
+}
+
+// Print adds the given text to the output followed by a newline, as with the
+// Magma print statement.
+func (st *Stmt) Print(text string) {
+	st.s.printf(text + "\n")
+}
+
+// IndentPush increases the indent level of output.
+func (st *Stmt) IndentPush() {
+	st.s.indent++
+}
+
+// IndentPop decreases the indent level of output.
+func (st *Stmt) IndentPop() {
+	if st.s.indent > 0 {
+		st.s.indent--
+	}
+}
+
+// Tag writes a raw tag line with the given fields and data.
+func (st *Stmt) Tag(name string, fields []string, data string) {
+	st.s.tag(name, fields, data)
+}
+
+// List writes list output (LST tags), one line per line of text.
+func (st *Stmt) List(text string) {
+	st.s.lines("LST", text)
+}
+
+// Signature writes signature output (SIG tags), one line per line of text.
+func (st *Stmt) Signature(text string) {
+	st.s.lines("SIG", text)
+}
+
+// Traceback writes traceback output (TB tags), one line per line of text.
+func (st *Stmt) Traceback(text string) {
+	st.s.lines("TB", text)
+}
+
+// ErrorPosition writes error position output (EPO tags), one line per line of text.
+func (st *Stmt) ErrorPosition(text string) {
+	st.s.lines("EPO", text)
+}
+
+// Position writes the position of an error in the input (POS tag).
+func (st *Stmt) Position(row, column int) {
+	st.s.flush()
+	st.s.tag("POS", []string{strconv.Itoa(row), strconv.Itoa(column)}, "")
+}
+
+// UserError writes a user error (EU tags).
+func (st *Stmt) UserError(msg string) {
+	st.s.lines("EU", msg)
+}
+
+// RuntimeError writes a runtime error (ER tags).
+func (st *Stmt) RuntimeError(msg string) {
+	st.s.lines("ER", msg)
+}
+
+// InternalError writes an internal error (EI tags).
+func (st *Stmt) InternalError(msg string) {
+	st.s.lines("EI", msg)
+}
+
+// SyntaxError reports that the input finished before the statement was
+// complete (ENE tag).
+func (st *Stmt) SyntaxError() {
+	st.s.flush()
+	st.s.tag("ENE", nil, "")
+}
+
+// Quit ends the session once the handler returns.
+func (st *Stmt) Quit() {
+	st.quit = true
+}
+
+// Set assigns a value to a session variable, as used by the builtin
+// statements.  Values are stored in their printed form.
+func (st *Stmt) Set(name, value string) {
+	st.s.vars[name] = value
+}
+
+// Get returns the value of a session variable.
+func (st *Stmt) Get(name string) (string, bool) {
+	v, ok := st.s.vars[name]
+	return v, ok
+}
+
+// Seed returns the random seed and step of the session.
+func (st *Stmt) Seed() (seed uint, step uint64) {
+	return st.s.seed, st.s.step
+}
+
+// SetSeed sets the random seed and step of the session, as with the Magma
+// SetSeed intrinsic.  They are reported in the RUN tags of later statements.
+func (st *Stmt) SetSeed(seed uint, step uint64) {
+	st.s.seed, st.s.step = seed, step
+}
+
+// Random returns a pseudo-random integer in [0, n) which is determined by the
+// random seed and step of the session, and advances the step.
+func (st *Stmt) Random(n int) int {
+	// splitmix64 finalizer
+	x := uint64(st.s.seed)<<32 ^ st.s.step
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	st.s.step++
+	return int(x % uint64(n))
+}
+
+// Read prompts for a line of input (RD_PR and RD_IN tags), as with the Magma
+// read statement.  An error is returned if the session is interrupted or input
+// ends before the line is complete.
+func (st *Stmt) Read(prompt string) (string, error) {
+	st.s.flush()
+	st.prompt("RD_PR", prompt)
+	st.s.tag("RD_IN", nil, "")
+	return st.s.readUntil('\n', st.interrupted)
+}
+
+// ReadInt prompts for an integer (RDI_PR and RDI_IN tags), as with the Magma
+// readi statement.  Invalid input is reported with an RDI_ER tag and the
+// prompt is repeated.
+func (st *Stmt) ReadInt(prompt string) (int, error) {
+	st.s.flush()
+	for {
+		st.prompt("RDI_PR", prompt)
+		st.s.tag("RDI_IN", nil, "")
+		l, err := st.s.readUntil('\n', st.interrupted)
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(l))
+		if err == nil {
+			return n, nil
+		}
+		st.s.tag("RDI_ER", []string{"0"}, "Bad integer input, please try again")
+	}
+}
+
+// DebugHandler handles a command given to the debugger of a fake Magma
+// session, returning false once execution of the statement should continue.
+type DebugHandler func(st *Stmt, cmd string) bool
+
+// Debug stops the statement in the debugger, as Magma does at a breakpoint.
+// A DRDY tag is written before each debugger command is read (ending with
+// ^D), and the command is passed to h until it returns false.  An error is
+// returned if the session is interrupted or input ends.
+func (st *Stmt) Debug(h DebugHandler) error {
+	for {
+		st.s.flush()
+		st.s.tag("DRDY", nil, "")
+		cmd, err := st.s.readUntil(runCommandChar, st.interrupted)
+		if err != nil {
+			return err
+		}
+		if !h(st, strings.TrimSpace(cmd)) {
+			st.s.flush()
+			return nil
+		}
+	}
+}
+
+func (st *Stmt) prompt(name, prompt string) {
+	for _, l := range strings.Split(prompt, "\n") {
+		field := "0"
+		for n := st.s.lineLength(); n > 0 && len(l) > n; {
+			st.s.tag(name, []string{field}, l[:n])
+			l = l[n:]
+			field = "C"
+		}
+		st.s.tag(name, []string{field}, l)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables used by Magma.
+const (
+	EnvMagmaPath        = "MAGMA_PATH"
+	EnvMagmaUserSpec    = "MAGMA_USER_SPEC"
+	EnvMagmaMemoryLimit = "MAGMA_MEMORY_LIMIT"
+)
+
+// Options are typed options for launching a Magma process (see Process.Options).
+// The zero value gives the same command line as DefaultArgs.
+type Options struct {
+	Seed *uint // Initial random seed (-S), if nil Magma chooses the seed
+
+	// LoadStartupFile loads the user's startup file (MAGMA_STARTUP_FILE),
+	// by default it is skipped (-n).
+	LoadStartupFile bool
+
+	Dir string // Working directory of the process (if empty, the current directory)
+
+	MagmaPath []string // Directories to search for input files (MAGMA_PATH)
+	UserSpec  string   // User spec file (MAGMA_USER_SPEC)
+	SpecFiles []string // Further spec files to attach before the first command (see Statements)
+
+	// Env gives further environment variables (of the form "key=value").
+	// These override any values in the base environment (see Environ).
+	Env []string
+
+	MemoryLimit uint64 // Memory limit in bytes (MAGMA_MEMORY_LIMIT), zero for no limit
+	Columns     int    // Output line width (see Statements), zero for the default
+	NoWrap      bool   // Disable wrapping of output lines (see Statements)
+}
+
+// Validate checks that the options are consistent, and that any given files
+// and directories exist.
+func (o *Options) Validate() error {
+	if o.Dir != "" {
+		fi, err := os.Stat(o.Dir)
+		if err != nil {
+			return fmt.Errorf("magma/proc: invalid working directory: %v", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("magma/proc: invalid working directory: %v is not a directory", o.Dir)
+		}
+	}
+
+	for _, d := range o.MagmaPath {
+		if d == "" || strings.ContainsRune(d, os.PathListSeparator) {
+			return fmt.Errorf("magma/proc: invalid MagmaPath directory %q", d)
+		}
+	}
+
+	files := o.SpecFiles
+	if o.UserSpec != "" {
+		files = append([]string{o.UserSpec}, files...)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(o.path(f)); err != nil {
+			return fmt.Errorf("magma/proc: invalid spec file: %v", err)
+		}
+	}
+
+	for _, e := range o.Env {
+		k := envKey(e)
+		if k == "" || len(k) == len(e) {
+			return fmt.Errorf("magma/proc: invalid environment variable %q", e)
+		}
+		switch {
+		case k == EnvMagmaPath && o.MagmaPath != nil,
+			k == EnvMagmaUserSpec && o.UserSpec != "",
+			k == EnvMagmaMemoryLimit && o.MemoryLimit != 0:
+			return fmt.Errorf("magma/proc: %v is set in both Env and Options", k)
+		}
+	}
+
+	if o.Columns < 0 {
+		return errors.New("magma/proc: Columns must not be negative")
+	}
+	if o.NoWrap && o.Columns > 0 {
+		return errors.New("magma/proc: Columns cannot be set with NoWrap")
+	}
+	return nil
+}
+
+// path returns the path to the file f as seen from the process.
+func (o *Options) path(f string) string {
+	if o.Dir == "" || strings.HasPrefix(f, string(os.PathSeparator)) {
+		return f
+	}
+	return o.Dir + string(os.PathSeparator) + f
+}
+
+// Args returns the command line arguments given by the options (used in place
+// of DefaultArgs).
+func (o *Options) Args() []string {
+	args := []string{"-x"}
+	if !o.LoadStartupFile {
+		args = append(args, "-n")
+	}
+	args = append(args, "-b")
+	if o.Seed != nil {
+		args = append(args, "-S", strconv.FormatUint(uint64(*o.Seed), 10))
+	}
+	return args
+}
+
+// Environ returns the environment given by the options, based on the given
+// environment (or the environment of the current process if base is nil).
+func (o *Options) Environ(base []string) []string {
+	if base == nil {
+		base = os.Environ()
+	}
+
+	var set []string
+	if o.MagmaPath != nil {
+		set = append(set, EnvMagmaPath+"="+strings.Join(o.MagmaPath, string(os.PathListSeparator)))
+	}
+	if o.UserSpec != "" {
+		set = append(set, EnvMagmaUserSpec+"="+o.UserSpec)
+	}
+	if o.MemoryLimit != 0 {
+		set = append(set, EnvMagmaMemoryLimit+"="+strconv.FormatUint(o.MemoryLimit, 10))
+	}
+	set = append(set, o.Env...)
+
+	override := make(map[string]bool, len(set))
+	for _, e := range set {
+		override[envKey(e)] = true
+	}
+
+	env := make([]string, 0, len(base)+len(set))
+	for _, e := range base {
+		if !override[envKey(e)] {
+			env = append(env, e)
+		}
+	}
+	return append(env, set...)
+}
+
+// Statements returns the Magma statements which apply the options that cannot
+// be given on the command line or in the environment (attaching SpecFiles and
+// setting Columns or NoWrap).  A Process runs these once Magma is ready for
+// input, before any command (see Process.Start).  Returns the empty string if there are none.
+func (o *Options) Statements() string {
+	var stmts []string
+	for _, f := range o.SpecFiles {
+		stmts = append(stmts, fmt.Sprintf("AttachSpec(%v);", Quote(f)))
+	}
+	if o.Columns > 0 || o.NoWrap {
+		stmts = append(stmts, fmt.Sprintf("SetColumns(%d);", o.Columns))
+	}
+	return strings.Join(stmts, "\n")
+}
+
+// envKey returns the key of an environment variable "key=value".
+func envKey(e string) string {
+	if i := strings.IndexByte(e, '='); i >= 0 {
+		return e[:i]
+	}
+	return e
+}
@@ -0,0 +1,168 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptionsArgs(t *testing.T) {
+	seed := uint(42)
+	tests := []struct {
+		o        Options
+		expected []string
+	}{
+		{Options{}, strings.Fields(DefaultArgs)},
+		{Options{LoadStartupFile: true}, []string{"-x", "-b"}},
+		{Options{Seed: &seed}, []string{"-x", "-n", "-b", "-S", "42"}},
+	}
+
+	for _, tt := range tests {
+		if args := tt.o.Args(); !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("expected Args() %v, got %v", tt.expected, args)
+		}
+	}
+}
+
+func TestOptionsEnviron(t *testing.T) {
+	o := &Options{
+		MagmaPath:   []string{"/a", "/b"},
+		UserSpec:    "spec",
+		MemoryLimit: 1 << 30,
+		Env:         []string{"HOME=/tmp", "X=1"},
+	}
+	base := []string{"HOME=/home/user", "MAGMA_PATH=/c", "PATH=/bin"}
+
+	expected := []string{
+		"PATH=/bin",
+		"MAGMA_PATH=/a" + string(os.PathListSeparator) + "/b",
+		"MAGMA_USER_SPEC=spec",
+		"MAGMA_MEMORY_LIMIT=1073741824",
+		"HOME=/tmp",
+		"X=1",
+	}
+	if env := o.Environ(base); !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected Environ() %v, got %v", expected, env)
+	}
+}
+
+func TestOptionsStatements(t *testing.T) {
+	o := &Options{SpecFiles: []string{"a/spec", "b"}, Columns: 120}
+	expected := "AttachSpec(\"a/spec\");\nAttachSpec(\"b\");\nSetColumns(120);"
+	if s := o.Statements(); s != expected {
+		t.Errorf("expected Statements() %q, got %q", expected, s)
+	}
+	o = &Options{SpecFiles: []string{`C:\spec "x"`}}
+	expected = `AttachSpec("C:\\spec \"x\"");`
+	if s := o.Statements(); s != expected {
+		t.Errorf("expected Statements() %q, got %q", expected, s)
+	}
+	if s := (&Options{NoWrap: true}).Statements(); s != "SetColumns(0);" {
+		t.Errorf("expected Statements() %q, got %q", "SetColumns(0);", s)
+	}
+	if s := (&Options{}).Statements(); s != "" {
+		t.Errorf("expected empty Statements(), got %q", s)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "magma-proc")
+	checkFatalf(t, "TempDir() error: %v", err)
+	defer os.RemoveAll(dir)
+	spec := filepath.Join(dir, "spec")
+	checkFatalf(t, "WriteFile() error: %v", ioutil.WriteFile(spec, nil, 0644))
+	quoted := filepath.Join(dir, "sp\"ec")
+	checkFatalf(t, "WriteFile() error: %v", ioutil.WriteFile(quoted, nil, 0644))
+
+	valid := []Options{
+		{},
+		{Dir: dir, SpecFiles: []string{"spec"}, UserSpec: spec},
+		{SpecFiles: []string{quoted}},
+		{MagmaPath: []string{dir}, Env: []string{"X="}, Columns: 80},
+	}
+	for _, o := range valid {
+		checkErrorf(t, "unexpected Validate() error: %v", o.Validate())
+	}
+
+	invalid := []Options{
+		{Dir: spec},
+		{Dir: filepath.Join(dir, "missing")},
+		{SpecFiles: []string{filepath.Join(dir, "missing")}},
+		{MagmaPath: []string{""}},
+		{Env: []string{"X"}},
+		{Env: []string{"=1"}},
+		{Env: []string{"MAGMA_USER_SPEC=x"}, UserSpec: spec},
+		{Columns: -1},
+		{Columns: 80, NoWrap: true},
+	}
+	for _, o := range invalid {
+		if err := o.Validate(); err == nil {
+			t.Errorf("expected Validate() error for %+v", o)
+		}
+	}
+}
+
+func TestProcessOptions(t *testing.T) {
+	p := newTestProcess()
+	p.Options = &Options{Dir: os.TempDir(), Env: []string{"X="}}
+	test := func(p *Process, t errorfer, st <-chan Tagged) {
+		go emptyTaggedChToLogPrintf("Status tag received: %v", st)
+		testQuitAndWait(p, t)
+	}
+	err := runCustomProcess(p, test, t)
+	checkErrorf(t, "unexpected error: %v", err)
+
+	// Statements applying the options are run before the first command
+	dir := t.TempDir()
+	spec, bad := filepath.Join(dir, "a.spec"), filepath.Join(dir, "bad.spec")
+	for _, f := range []string{spec, bad} {
+		checkFatalf(t, "WriteFile() error: %v", ioutil.WriteFile(f, nil, 0644))
+	}
+
+	var buf bytes.Buffer
+	p = newFakeProcess()
+	p.Options = &Options{SpecFiles: []string{spec}, NoWrap: true}
+	p.Transcript = &buf
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+	res, err := p.Run("1;")
+	checkFatalf(t, "Run() error: %v", err)
+	if res.Text() != "1" {
+		t.Errorf("expected output %q, got %q", "1", res.Text())
+	}
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+	tr := buf.String()
+	i, j := strings.Index(tr, "AttachSpec"), strings.Index(tr, `"1;"`)
+	if i < 0 || j < i || !strings.Contains(tr, "SetColumns(0);") {
+		t.Errorf("expected options to be applied before the first command, got transcript:\n%v", tr)
+	}
+
+	p = newFakeProcess()
+	p.Options = &Options{SpecFiles: []string{bad}}
+	so, err = p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	if _, err := p.ReadStartup(so); err == nil {
+		t.Errorf("expected ReadStartup() error when options cannot be applied")
+	}
+	if _, err := p.Execute("1;"); err == nil {
+		t.Errorf("expected Execute() error when options cannot be applied")
+	}
+	testQuitAndWait(p, t)
+	p.Wait()
+
+	p = newTestProcess()
+	p.Options = &Options{Columns: -1}
+	if _, err := p.Start(); err == nil {
+		t.Errorf("expected Start() error for invalid Options")
+	}
+}
@@ -5,11 +5,15 @@
 package proc
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"strconv"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Special characters used in communication
@@ -20,6 +24,65 @@ const (
 
 var newTagSlice = []byte{newTagChar}
 
+// maxUntaggedLines is the number of untagged lines kept from startup output.
+const maxUntaggedLines = 100
+
+// errNoTagLine is returned by the parser when output ends unexpectedly.
+var errNoTagLine = errors.New("waiting for tag line")
+
+// DefaultMaxLineLength is the default maximum length of a line of Magma
+// output (see Process.MaxLineLength).
+const DefaultMaxLineLength = 64 << 20
+
+// truncatedFormat is the marker appended to lines which have been truncated,
+// with the number of bytes which were discarded.
+const truncatedFormat = "... [%d bytes truncated]"
+
+// rawLine is a line of Magma output as read by readLine.
+type rawLine struct {
+	data      []byte
+	truncated bool // data was truncated, and ends with the truncation marker
+}
+
+// readLine reads a line from r, without the line ending.  If max is positive,
+// then bytes beyond the first max are discarded and a truncation marker is
+// appended to the line.  Returns io.EOF when there are no more lines.
+func readLine(r *bufio.Reader, max int) (rawLine, error) {
+	var line []byte
+	discarded := 0
+	for {
+		b, err := r.ReadSlice('\n')
+		if err == nil {
+			b = b[:len(b)-1]
+		}
+		if max > 0 && len(line)+len(b) > max {
+			n := max - len(line)
+			discarded += len(b) - n
+			b = b[:n]
+		}
+		line = append(line, b...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && (len(line) > 0 || discarded > 0) {
+			break
+		}
+		if err != nil {
+			return rawLine{}, err
+		}
+		break
+	}
+
+	if n := len(line); discarded == 0 && n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	if discarded > 0 {
+		line = append(line, fmt.Sprintf(truncatedFormat, discarded)...)
+	}
+	return rawLine{data: line, truncated: discarded > 0}, nil
+}
+
 // parseTagLine takes a line represented as a byte slice, and returns
 // the tag, tag fields, and data (if any).
 func parseTagLine(line []byte) (tagName []byte, tagFields [][]byte, data []byte) {
@@ -40,34 +103,80 @@ func parseTagLine(line []byte) (tagName []byte, tagFields [][]byte, data []byte)
 	return
 }
 
-// Parse the output from the underlying Magma process, and return it
-// on one of two channels: `status`, or `output`.  The status channel
-// is per-session and gives status flags and other status messages. The
-// 'output' channel is per-execution (per call to Execute()) and returns
-// the produced output, passed back to the user via the Process.output
-// channel.
-func (p *Process) parseStdoutLines(ch <-chan []byte) error {
-	// Create the response handler for the entire session
+// Parse the output from the underlying Magma process, and either publish
+// it as a status event, or return it as output.  Status events are
+// per-session and give status flags and other status messages (see
+// Subscribe). Output is per-execution (per call to Execute()) and is
+// passed back to the user via the Process.output channel.
+func (p *Process) parseStdoutLines(ch <-chan rawLine) (err error) {
+	// Create the response handler for the entire session, closing any
+	// incomplete output when the session ends (marking it as exited unless
+	// Magma quit)
 	h := &rhandler{}
+	defer func() {
+		if err != nil {
+			h.exited()
+		}
+		h.close()
+	}()
 
 	// Setup the response object for startup output
-	r := newOutput("<startup>")
+	r := p.newOutput("<startup>", "<startup>")
 	h.init(r)
 	p.response <- r
 	h.start()
+	p.events.publish(EventStarted, nil)
 
 	var rch chan *Output
-	var done bool
+	var done, started bool
+
+	// Measurements of the running command (see Instrumentation)
+	inst := p.instrumentation()
+	var stats *CommandStats
+	var cmdStart time.Time
+
+	// Output from debugger commands is given to the Output passed on dch
+	// (see Debugger), the session output resumes once the debugger is left
+	dh := &rhandler{}
+	var dch chan *Output
+	defer func() {
+		select {
+		case o := <-dch:
+			dh.init(o)
+		default:
+		}
+		if err != nil {
+			dh.exited()
+		}
+		dh.close()
+	}()
+	var debugging bool
 
 	for {
 		output, ok := <-ch
 		if !ok {
-			return errors.New("waiting for tag line")
+			return errNoTagLine
 		}
 
-		if tagName, tagFields, data := parseTagLine(output); tagName != nil {
+		if tagName, tagFields, data := parseTagLine(output.data); tagName != nil {
+			data = p.Encoding.decode(data)
 			done = true
 
+			out := h
+			if debugging {
+				if dh.r == nil {
+					select {
+					case o := <-dch:
+						dh.init(o)
+						dh.start()
+					default:
+					}
+				}
+				if dh.r != nil {
+					out = dh
+				}
+			}
+
 			// Switch for status tags
 			switch tag := statusTag(tagName); tag {
 			case TagReady:
@@ -75,30 +184,71 @@ func (p *Process) parseStdoutLines(ch <-chan []byte) error {
 				if err != nil {
 					return err
 				}
-				p.status <- r
+				p.sm.set(StateReady)
+				p.events.publish(EventStatus, r)
+				if stats != nil {
+					stats.Latency = time.Since(cmdStart)
+					inst.CommandDone(*stats)
+					stats = nil
+				}
+				if debugging {
+					debugging = false
+					dh.ready()
+					dch = nil
+					select {
+					case <-p.debugReady:
+					default:
+					}
+				}
+
+				if !started {
+					started = true
+					if p.started != nil {
+						close(p.started)
+					}
+				}
 
 				if h.ready() {
 					rch = make(chan *Output, 1)
 					p.ready <- rch
 				}
 
+			case TagDebugReady:
+				p.sm.set(StateDebugging)
+				p.events.publish(EventStatus, &Status{tag: statusTag(tag)})
+				debugging = true
+				dh.ready()
+				select {
+				case <-p.debugReady:
+				default:
+				}
+				dch = make(chan *Output, 1)
+				p.debugReady <- dch
+
 			case TagInputReceived:
-				p.status <- &Status{tag: statusTag(tag)}
+				if debugging {
+					// Input to the debugger
+					break
+				}
+				p.sm.set(StateRunning)
+				p.events.publish(EventStatus, &Status{tag: statusTag(tag)})
 				select {
 				case r := <-rch:
 					h.init(r)
 					close(rch)
 					rch = nil
 					p.response <- r
+					stats, cmdStart = &CommandStats{Command: r.cmd}, time.Now()
 				default:
 					return errors.New("expected Output to be waiting")
 				}
 
 			case TagReset:
-				p.status <- &Status{tag: statusTag(tag)}
+				p.events.publish(EventStatus, &Status{tag: statusTag(tag)})
 
 			case TagQuit:
-				p.status <- &Status{tag: statusTag(tag)}
+				p.sm.set(StateQuitting)
+				p.events.publish(EventStatus, &Status{tag: statusTag(tag)})
 				select {
 				case qch := <-p.quit:
 					close(qch)
@@ -109,7 +259,9 @@ func (p *Process) parseStdoutLines(ch <-chan []byte) error {
 				return nil
 
 			case TagInterrupt:
-				p.status <- &Status{tag: tag}
+				inst.Interrupted()
+				p.sm.set(StateInterrupted)
+				p.events.publish(EventStatus, &Status{tag: tag})
 				select {
 				case ich := <-p.interrupt:
 					close(ich)
@@ -119,20 +271,26 @@ func (p *Process) parseStdoutLines(ch <-chan []byte) error {
 				}
 
 			case TagRun:
-				p.status <- &Status{tag: tag}
+				p.events.publish(EventStatus, &Status{tag: tag})
 				chk, seed, err := parseRun(tagFields)
 				if err != nil {
 					return err
 				}
-				h.run(chk, seed)
+				p.seeds.set(seed)
+				out.run(chk, seed)
+				if stats != nil {
+					stats.Statements++
+					stats.PerStatement = append(stats.PerStatement, StatementStats{Start: chk.start, End: chk.end})
+				}
 
 			case TagErrorParse:
-				p.status <- &Status{tag: tag}
+				p.events.publish(EventStatus, &Status{tag: tag})
 				chk, err := parseResponse(tagFields)
 				if err != nil {
 					return fmt.Errorf("ERP parsing Response: %v", err)
 				}
-				h.parseError(chk)
+				out.parseError(chk)
+				inst.ParseError()
 
 			default:
 				done = false
@@ -143,38 +301,71 @@ func (p *Process) parseStdoutLines(ch <-chan []byte) error {
 			}
 
 			// Switch for output/data tags
-			switch tag := tag(tagName); tag {
+			switch tag := Tag(tagName); tag {
 			case TagErrorHistoryPosition:
 				p, err := parseHistoryPosition(tagFields)
 				if err != nil {
 					return err
 				}
-				h.send(&p)
+				out.send(&p)
 
 			case TagOutput, TagList, TagErrorUser, TagErrorRuntime, TagErrorInternal,
 				TagErrorPosition, TagTraceback, TagSignature:
-				o, err := parseOutput(tag, tagFields, data)
+				o, err := parseOutput(tag, tagFields, data, output.truncated)
 				if err != nil {
 					return err
 				}
 				if tag == TagErrorInternal {
-					h.internalError()
+					out.internalError()
+					inst.InternalError()
 				}
-				h.send(o)
+				if stats != nil {
+					stats.OutputBytes += int64(len(o.Data))
+					if n := len(stats.PerStatement); n > 0 {
+						stats.PerStatement[n-1].OutputBytes += int64(len(o.Data))
+					}
+				}
+				p.sendLine(out, o)
 
 			case TagErrorSyntax:
-				h.send(&Line{tag: tag})
+				out.send(&Line{tag: tag})
 
 			case TagReadPrompt, TagReadIntPrompt:
-				err := p.parseReadPrompt(tag, tagFields, data, h, ch)
+				err := p.parseReadPrompt(tag, tagFields, data, out, ch)
 				if err != nil {
 					return err
 				}
+
+			case TagReadIntError:
+				// Magma rejected the answer, and will prompt again
+				if p.lastRead != nil {
+					p.readRetry = p.lastRead.retry(&ReadIntError{Input: p.lastAnswer, Message: string(data)})
+				}
 			}
+		} else if !started && len(p.untagged) < maxUntaggedLines {
+			p.untagged = append(p.untagged, string(p.Encoding.decode(output.data)))
 		}
 	}
 }
 
+// sendLine sends the line l, split into chunks of at most p.LineChunkSize
+// bytes (if set).  Every chunk after the first is marked as a continuation.
+func (p *Process) sendLine(h *rhandler, l *Line) {
+	n := p.LineChunkSize
+	for n > 0 && len(l.Data) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(l.Data[i]) {
+			i--
+		}
+		if i == 0 {
+			i = n
+		}
+		h.send(&Line{tag: l.tag, Continuation: l.Continuation, Indent: l.Indent, Data: l.Data[:i]})
+		l = &Line{tag: l.tag, Continuation: true, Indent: l.Indent, Data: l.Data[i:], Truncated: l.Truncated}
+	}
+	h.send(l)
+}
+
 func parseReady(tagFields [][]byte) (r *Ready, err error) {
 	if len(tagFields) != 5 {
 		err = errors.New("parsing RDY: require 5 parameters")
@@ -216,6 +407,7 @@ func parseRun(tagFields [][]byte) (c chunk, s *Seed, err error) {
 func parseResponse(tagFields [][]byte) (c chunk, err error) {
 	if len(tagFields) != 4 {
 		err = errors.New("response output not of required form")
+		return
 	}
 	c.start, err = parseHistoryPosition(tagFields[:2])
 	if err != nil {
@@ -245,7 +437,7 @@ func parseHistoryPosition(tagFields [][]byte) (pos Position, err error) {
 	return
 }
 
-func parseOutput(tag tag, tagFields [][]byte, data []byte) (output *Line, err error) {
+func parseOutput(tag Tag, tagFields [][]byte, data []byte, truncated bool) (output *Line, err error) {
 	if len(tagFields) != 1 {
 		err = errors.New("output tag not of required form")
 		return
@@ -260,11 +452,17 @@ func parseOutput(tag tag, tagFields [][]byte, data []byte) (output *Line, err er
 		}
 	}
 	output.Data = string(bytes.TrimRightFunc(data, unicode.IsSpace))
+	output.Truncated = truncated
 	return
 }
 
-func (p *Process) parseReadPrompt(tag tag, tagFields [][]byte, data []byte, h *rhandler, ch <-chan []byte) error {
+func (p *Process) parseReadPrompt(tag Tag, tagFields [][]byte, data []byte, h *rhandler, ch <-chan rawLine) error {
 	r := &ReadRequest{tag: tag, Output: make(chan string), Err: make(chan error)}
+	if p.readRetry != nil && p.readRetry.tag == tag {
+		r = p.readRetry
+		r.Prompt = ""
+	}
+	p.readRetry, p.lastRead = nil, nil
 	r.Prompt += string(data)
 
 READ_FORLOOP:
@@ -273,30 +471,39 @@ READ_FORLOOP:
 		if !ok {
 			return errors.New("expected tag line (RD or RD_END)")
 		}
-		if tag, tagFields, data := parseTagLine(output); tag != nil {
+		if tag, tagFields, data := parseTagLine(output.data); tag != nil {
 			switch tag := string(tag); tag {
 			case TagReadPrompt, TagReadIntPrompt:
 				if string(tagFields[0]) != "C" {
 					r.Prompt += "\n"
 				}
-				r.Prompt += string(data)
+				r.Prompt += string(p.Encoding.decode(data))
 				continue READ_FORLOOP
 			case TagReadInput, TagReadIntInput:
-				// Send the read request
-				h.send(r)
-				// Listen for the response
-				var err error
-				select {
-				case response := <-r.Output:
-					w := <-p.writer
-					_, err = w.Write([]byte(response))
+				// Answer the read request
+				p.sm.set(StateReadingInput)
+				response, err := p.answerRead(r, h)
+				if _, ok := err.(*ReadError); ok {
+					p.abortRead(h, err)
+					break READ_FORLOOP
+				}
+				if err != nil {
+					return err
+				}
+				p.lastRead, p.lastAnswer = r, response
+
+				response, _ = sanitizeInput(response, InputStrip, true, p.Encoding)
+				response, _ = p.Encoding.encode(response, true)
+				w := <-p.writer
+				_, err = w.Write([]byte(response))
+				if err == nil {
 					_, err = w.Write([]byte("\n"))
-					p.writer <- w
-				case err = <-r.Err:
 				}
+				p.writer <- w
 				if err != nil {
 					return err
 				}
+				p.sm.set(StateRunning)
 				break READ_FORLOOP
 			default:
 				return errors.New("expected RD_PR or RD_IN tag")
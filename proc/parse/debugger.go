@@ -0,0 +1,39 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"fmt"
+
+	"github.com/dhowden/magma/proc"
+)
+
+// Frames returns the frames of the process stopped in the debugger d, parsed
+// from the output of its backtrace (the current frame is marked Current).
+func Frames(d *proc.Debugger) ([]*Traceback, error) {
+	res, err := d.Backtrace()
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	var lines []*proc.Line
+	for _, st := range res.Statements {
+		lines = append(lines, st.Lines[proc.TagTraceback]...)
+	}
+
+	var frames []*Traceback
+	for _, x := range runParser(&TracebackParser{}, lines) {
+		switch x := x.(type) {
+		case *Traceback:
+			frames = append(frames, x)
+		case error:
+			return nil, fmt.Errorf("parsing backtrace: %v", x)
+		}
+	}
+	return frames, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dhowden/magma/proc"
+	"github.com/dhowden/magma/proc/magmatest"
+)
+
+// fakeDebugName is the name of the fake Magma used by TestFrames.
+const fakeDebugName = "parse-debug"
+
+func TestMain(m *testing.M) {
+	f := &magmatest.Fake{}
+	f.Handle("f(3);", func(s *magmatest.Stmt) {
+		s.Debug(func(s *magmatest.Stmt, cmd string) bool {
+			if cmd != "bt" {
+				return false
+			}
+			s.Traceback("#0 *f(\n    x: 3\n) at /tmp/f.m:2\n#1 <main>(\n) at <main>:1")
+			return true
+		})
+	})
+	magmatest.Register(fakeDebugName, f)
+	magmatest.Main()
+	os.Exit(m.Run())
+}
+
+func TestFrames(t *testing.T) {
+	cmd, env := magmatest.Command(fakeDebugName)
+	p := &proc.Process{Command: cmd, Env: env}
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	proc.Discard(so.Output())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Run("f(3);")
+		done <- err
+	}()
+
+	d := p.Debugger()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	checkFatalf(t, "WaitStopped() error: %v", d.WaitStopped(ctx))
+	cancel()
+
+	frames, err := Frames(d)
+	checkFatalf(t, "Frames() error: %v", err)
+	expected := []*Traceback{
+		{
+			Index:    0,
+			Current:  true,
+			Name:     "f",
+			Params:   []ParamValue{{Name: "x", Value: "3"}},
+			Location: Location{File: "/tmp/f.m", Row: 2},
+		},
+		{
+			Index:    1,
+			Name:     "<main>",
+			Location: Location{File: "<main>", Row: 1},
+		},
+	}
+	if !reflect.DeepEqual(frames, expected) {
+		t.Errorf("expected Frames() %v, got %v", expected, frames)
+	}
+
+	_, err = d.Continue()
+	checkErrorf(t, "Continue() error: %v", err)
+	checkErrorf(t, "Run() error: %v", <-done)
+
+	q, err := p.Quit()
+	checkFatalf(t, "Quit() error: %v", err)
+	<-q
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
@@ -0,0 +1,106 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dhowden/magma/proc"
+)
+
+// Latin-1 encoded accented file paths: "/home/hélène/è.m" (and the UTF-8
+// encoding of "/srv/josé/ñ.m" which is passed through unchanged).
+const encodingTranscript = `< "\x81RDY 0 0 0 0 0"
+> "load \"/home/h\xe9l\xe8ne/\xe8.m\";"
+> "\x04"
+< "\x81IR"
+< "\x81RUN 1 0 0 0 0 25"
+< "\x81EPO 0\x81In file \"/home/h\xe9l\xe8ne/\xe8.m\", line 3, column 5:"
+< "\x81EPO 0\x81>> x := 1 mod 0;"
+< "\x81EPO 0\x81Located in file \"/srv/jos\xc3\xa9/\xc3\xb1.m\", at line 1, column 1:"
+< "\x81EPO 0\x81>> load \"/home/h\xe9l\xe8ne/\xe8.m\";"
+< "\x81ER 0\x81Runtime error in 'mod': Division by zero"
+< "\x81RDY 0 0 0 0 0"
+> "Caf\xe9;"
+> "\x04"
+< "\x81IR"
+< "\x81RUN 1 1 0 0 0 5"
+< "\x81SIG 0\x81Intrinsic 'Caf\xe9'"
+< "\x81SIG 0\x81"
+< "\x81SIG 0\x81Signatures:"
+< "\x81SIG 0\x81"
+< "\x81SIG 0\x81    Defined in file: /home/h\xe9l\xe8ne/\xe8.m, line 10, column 1:"
+< "\x81SIG 0\x81    (x::RngIntElt) -> RngIntElt"
+< "\x81SIG 0\x81"
+< "\x81SIG 0\x81        Caf\xe9 cr\xe8me."
+< "\x81SIG 0\x81"
+< "\x81RDY 0 0 0 0 0"
+`
+
+// replayEncoding replays encodingTranscript and runs the parser p on the
+// output of the command cmd.
+func replayEncoding(t *testing.T, cmd string, p TaggedParser) []interface{} {
+	var out []interface{}
+	tested := false
+	err := proc.Replay(strings.NewReader(encodingTranscript), func(o *proc.Output) error {
+		if o.Command() != cmd {
+			return nil
+		}
+		tested = true
+		ch := make(chan proc.Tagged, 100)
+		for x := range o.Output() {
+			ch <- x
+		}
+		close(ch)
+		for x := range p.Run(ch) {
+			out = append(out, x)
+		}
+		return nil
+	})
+	checkErrorf(t, "Replay() error: %v", err)
+	if !tested {
+		t.Fatalf("transcript did not contain command: %v", cmd)
+	}
+	return out
+}
+
+func TestErrorPositionAccentedPaths(t *testing.T) {
+	out := replayEncoding(t, `load "/home/hélène/è.m";`, &ErrorPositionParser{})
+	if len(out) != 1 {
+		t.Fatalf("expected 1 error position, got %d: %v", len(out), out)
+	}
+
+	verifyErrorPosition(&ErrorPosition{
+		File:           "/home/hélène/è.m",
+		Row:            3,
+		Column:         5,
+		SourceFragment: "x := 1 mod 0;",
+		LocatedIn: &ErrorPosition{
+			File:           "/srv/josé/ñ.m",
+			Row:            1,
+			Column:         1,
+			SourceFragment: `load "/home/hélène/è.m";`,
+		},
+	})(out[0], t)
+}
+
+func TestSignatureAccentedPaths(t *testing.T) {
+	out := replayEncoding(t, "Café;", &SignatureParser{})
+	if len(out) != 1 {
+		t.Fatalf("expected 1 signature, got %d: %v", len(out), out)
+	}
+
+	verifySignature(&Signature{
+		Intrinsic: "Café",
+		Location: SignatureLocation{
+			Location: Location{File: "/home/hélène/è.m", Row: 10},
+			Column:   1,
+		},
+		Params:  []Param{Param{Name: "x", Type: "RngIntElt"}},
+		Returns: []string{"RngIntElt"},
+		Comment: "Café crème.",
+	})(out[0], t)
+}
@@ -5,11 +5,8 @@
 package parse
 
 import (
-	"errors"
-	"strconv"
-	"strings"
-
 	"github.com/dhowden/magma/proc"
+	"github.com/dhowden/magma/proc/internal/lineparse"
 )
 
 // General constants used in parsing Magma output
@@ -22,8 +19,8 @@ const (
 
 // parser is the common interface shared by all parsers
 type parser interface {
-	// start the parser with the given lineConsumer, and return the parsed objects
-	start(*lineConsumer) <-chan interface{}
+	// start the parser with the given line consumer, and return the parsed objects
+	start(*lineparse.Consumer) <-chan interface{}
 }
 
 // TaggedParser is the (public) common interface implemented by all proc parsers
@@ -97,33 +94,19 @@ IN_LOOP:
 	close(out)
 }
 
-// Splitting function for commas
-func matchCommaRune(r rune) bool {
-	return r == ','
-}
-
-// Extract the line and column where fields are:
-// `[at] line x` and `column y`
-func extractRowColumnFromFieldsSplit(fields []string) (row, col int, err error) {
-	if len(fields) != 2 {
-		err = errors.New("expect 2 elements in expansion of line/column location data")
-		return
-	}
+// runParser runs the parser p on the given lines and returns all of its output.
+func runParser(p TaggedParser, lines []*proc.Line) []interface{} {
+	src := make(chan proc.Tagged)
+	go func() {
+		for _, l := range lines {
+			src <- l
+		}
+		close(src)
+	}()
 
-	lineSplit := strings.Fields(fields[0])
-	row, err = strconv.Atoi(lineSplit[len(lineSplit)-1])
-	if err != nil {
-		return
+	var out []interface{}
+	for x := range p.Run(src) {
+		out = append(out, x)
 	}
-
-	col, err = strconv.Atoi(strings.Fields(fields[1])[1])
-	return
-}
-
-// Extract the line and column numbers from a string with format:
-// `[at] line x, column y` where x and y are integers
-func extractRowColumnFromString(input string) (row, column int, err error) {
-	fields := strings.FieldsFunc(input, matchCommaRune)
-	row, column, err = extractRowColumnFromFieldsSplit(fields)
-	return
+	return out
 }
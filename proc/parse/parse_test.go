@@ -10,9 +10,10 @@ import (
 	"time"
 
 	"github.com/dhowden/magma/proc"
+	"github.com/dhowden/magma/proc/internal/lineparse"
 )
 
-func outputLineConsumerProvider(arr []string) *lineConsumer {
+func outputLineConsumerProvider(arr []string) *lineparse.Consumer {
 	ch := make(chan string)
 	go func() {
 		for _, x := range arr {
@@ -20,7 +21,7 @@ func outputLineConsumerProvider(arr []string) *lineConsumer {
 		}
 		close(ch)
 	}()
-	return newLineConsumer(ch)
+	return lineparse.NewConsumer(ch)
 }
 
 type verifyFn func(interface{}, *testing.T)
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/dhowden/magma/proc"
+	"github.com/dhowden/magma/proc/internal/lineparse"
 )
 
 // Param stores intrinsic parameter name/type pairs.
@@ -37,10 +38,10 @@ type signatureParserStateFn func(*SignatureParser) signatureParserStateFn
 
 // SignatureParser is the container associated with parsing signatures.
 type SignatureParser struct {
-	*lineConsumer
-	err     error            // Parse error (if any)
-	current *Signature       // Current Signature object (in construction)
-	output  chan interface{} // Line channel for delivering completed signatures
+	lc      *lineparse.Consumer // Source of lines
+	err     error               // Parse error (if any)
+	current *Signature          // Current Signature object (in construction)
+	output  chan interface{}    // Line channel for delivering completed signatures
 
 	// When parsing a listing of signatures for a given intrinsic, the intrinsic
 	// name is given up front.  This also acts as a test for the kind of sig list
@@ -55,15 +56,15 @@ func (p *SignatureParser) Accepts(x proc.Tagged) bool {
 	return x.Tag() == proc.TagSignature
 }
 
-// Run creates a lineConsumer for the given channel of Tagged objects and starts
+// Run creates a line consumer for the given channel of Tagged objects and starts
 // the parser.  Resulting *Signature structs are passed back on the returned channel.
 func (p *SignatureParser) Run(source <-chan proc.Tagged) <-chan interface{} {
 	outputSource := taggedOutputSourceForLineConsumer(source, p)
-	return p.start(newLineConsumer(outputSource))
+	return p.start(lineparse.NewConsumer(outputSource))
 }
 
-func (p *SignatureParser) start(consumer *lineConsumer) <-chan interface{} {
-	p.lineConsumer = consumer
+func (p *SignatureParser) start(consumer *lineparse.Consumer) <-chan interface{} {
+	p.lc = consumer
 
 	p.current = &Signature{}
 	p.output = make(chan interface{})
@@ -88,8 +89,8 @@ func (p *SignatureParser) emit() {
 }
 
 func parseSignatureListHeader(p *SignatureParser) signatureParserStateFn {
-	if p.fetchNextLine() {
-		if line := strings.TrimPrefix(p.line, "Intrinsic '"); len(p.line) > len(line) {
+	if p.lc.FetchNextLine() {
+		if line := strings.TrimPrefix(p.lc.Line, "Intrinsic '"); len(p.lc.Line) > len(line) {
 			if p.intrinsic != "" {
 				p.err = fmt.Errorf("new listing, but already have intrisic set")
 				return parseSignatureError
@@ -101,12 +102,12 @@ func parseSignatureListHeader(p *SignatureParser) signatureParserStateFn {
 			}
 
 			p.intrinsic = line[:len(line)-1]
-			p.consumeLine()
+			p.lc.ConsumeLine()
 		}
 
-		if line := strings.TrimPrefix(p.line, "Signatures matching "); len(p.line) > len(line) {
+		if line := strings.TrimPrefix(p.lc.Line, "Signatures matching "); len(p.lc.Line) > len(line) {
 			p.intrinsic = ""
-			p.consumeLine()
+			p.lc.ConsumeLine()
 		}
 		return parseSignature
 	}
@@ -116,18 +117,18 @@ func parseSignatureListHeader(p *SignatureParser) signatureParserStateFn {
 
 // Discard output until a signature param statement, and parse it
 func parseSignature(p *SignatureParser) signatureParserStateFn {
-	for p.fetchNextLine() {
-		if p.line != "" && p.line != "Signatures:" {
-			if line := strings.TrimPrefix(p.line, "Defined in file: "); len(p.line) > len(line) {
+	for p.lc.FetchNextLine() {
+		if p.lc.Line != "" && p.lc.Line != "Signatures:" {
+			if line := strings.TrimPrefix(p.lc.Line, "Defined in file: "); len(p.lc.Line) > len(line) {
 				// Defined in file: /Users/dhowden/etc/file.m, line 123, column 456:
-				fields := strings.FieldsFunc(line[:len(line)-1], matchCommaRune)
+				fields := strings.FieldsFunc(line[:len(line)-1], lineparse.MatchCommaRune)
 				if len(fields) < 3 {
 					// Expect fields[0] filename, fields[1,2] line, col:
 					p.err = errors.New("expected at least 3 chunks from comma split")
 					return parseSignatureError
 				}
 
-				line, col, err := extractRowColumnFromFieldsSplit(fields[1:])
+				line, col, err := lineparse.RowColumnFromFields(fields[1:])
 				if err != nil {
 					p.err = err
 					return parseSignatureError
@@ -140,29 +141,29 @@ func parseSignature(p *SignatureParser) signatureParserStateFn {
 					},
 					Column: int(col),
 				}
-			} else if strings.HasPrefix(p.line, "Defined in glue: ") {
+			} else if strings.HasPrefix(p.lc.Line, "Defined in glue: ") {
 				// Defined in glue: glue_function_name():
-				glue := strings.TrimPrefix(p.line, "Defined in glue: ")
+				glue := strings.TrimPrefix(p.lc.Line, "Defined in glue: ")
 				p.current.Location = SignatureLocation{
 					Location: Location{
 						Glue: glue[:len(glue)-1],
 					},
 				}
 			} else if p.intrinsic != "" {
-				if strings.HasPrefix(p.line, leftParam) {
+				if strings.HasPrefix(p.lc.Line, leftParam) {
 					p.current.Intrinsic = p.intrinsic
 					return parseParams
 				}
 			} else {
 				name := ""
 				for {
-					if index := strings.Index(p.line, leftParam); index == -1 {
-						name += p.line
-						p.consumeLine()
-						p.fetchNextLine()
+					if index := strings.Index(p.lc.Line, leftParam); index == -1 {
+						name += p.lc.Line
+						p.lc.ConsumeLine()
+						p.lc.FetchNextLine()
 					} else {
-						name += p.line[:index]
-						p.line = p.line[index:]
+						name += p.lc.Line[:index]
+						p.lc.Line = p.lc.Line[index:]
 						break
 					}
 				}
@@ -170,24 +171,24 @@ func parseSignature(p *SignatureParser) signatureParserStateFn {
 				return parseParams
 			}
 		}
-		p.consumeLine()
+		p.lc.ConsumeLine()
 	}
 	return nil
 }
 
 // Parse the params line
 func parseParams(p *SignatureParser) signatureParserStateFn {
-	l := p.line
-	p.consumeLine()
+	l := p.lc.Line
+	p.lc.ConsumeLine()
 	for {
-		p.fetchNextLine()
+		p.lc.FetchNextLine()
 		// stop when we get an empty line (before comment), or the beginning of
 		// optional params
-		if p.line == "" || p.line == leftOptionalParam {
+		if p.lc.Line == "" || p.lc.Line == leftOptionalParam {
 			break
 		}
-		l += p.line
-		p.consumeLine()
+		l += p.lc.Line
+		p.lc.ConsumeLine()
 	}
 
 	// Avoid the "()" case
@@ -216,10 +217,10 @@ func parseParams(p *SignatureParser) signatureParserStateFn {
 		}
 	}
 
-	if p.line == "" { // blank line preceeds comment
-		p.consumeLine()
+	if p.lc.Line == "" { // blank line preceeds comment
+		p.lc.ConsumeLine()
 		return parseComment
-	} else if p.line == leftOptionalParam {
+	} else if p.lc.Line == leftOptionalParam {
 		return parseOptionalParams
 	}
 	return nil
@@ -227,20 +228,20 @@ func parseParams(p *SignatureParser) signatureParserStateFn {
 
 // Parse optional param statement
 func parseOptionalParams(p *SignatureParser) signatureParserStateFn {
-	p.fetchNextLine()
+	p.lc.FetchNextLine()
 	optionalParams := ""
-	if strings.HasPrefix(p.line, leftOptionalParam) {
+	if strings.HasPrefix(p.lc.Line, leftOptionalParam) {
 		// Optional params appear
-		p.consumeLine()
-		for p.fetchNextLine() {
+		p.lc.ConsumeLine()
+		for p.lc.FetchNextLine() {
 			// Parse until a ] line
-			if strings.HasPrefix(p.line, rightOptionalParam) {
-				p.consumeLine()
+			if strings.HasPrefix(p.lc.Line, rightOptionalParam) {
+				p.lc.ConsumeLine()
 				// Get the next line, it should be empty
-				p.fetchNextLine()
-				p.consumeLine()
-				if p.line != "" {
-					p.err = fmt.Errorf("expected an empty line to follow optional params, got: %v", p.line)
+				p.lc.FetchNextLine()
+				p.lc.ConsumeLine()
+				if p.lc.Line != "" {
+					p.err = fmt.Errorf("expected an empty line to follow optional params, got: %v", p.lc.Line)
 					return parseSignatureError
 				}
 				if optionalParams != "" {
@@ -257,8 +258,8 @@ func parseOptionalParams(p *SignatureParser) signatureParserStateFn {
 				}
 				return parseComment
 			}
-			optionalParams += p.line
-			p.consumeLine()
+			optionalParams += p.lc.Line
+			p.lc.ConsumeLine()
 		}
 		panic("should not get here")
 	}
@@ -267,20 +268,20 @@ func parseOptionalParams(p *SignatureParser) signatureParserStateFn {
 
 // Parse signature comment (starts with a non-empty line)
 func parseComment(p *SignatureParser) signatureParserStateFn {
-	for p.fetchNextLine() {
+	for p.lc.FetchNextLine() {
 		// The end of the comment
-		if p.line == "" {
-			p.consumeLine()
+		if p.lc.Line == "" {
+			p.lc.ConsumeLine()
 			p.emit()
 			return parseSignature
 		}
 
 		if p.current.Comment == "" {
-			p.current.Comment = p.line
+			p.current.Comment = p.lc.Line
 		} else {
-			p.current.Comment += " " + p.line
+			p.current.Comment += " " + p.lc.Line
 		}
-		p.consumeLine()
+		p.lc.ConsumeLine()
 	}
 	return nil
 }
@@ -5,6 +5,7 @@
 package parse
 
 import (
+	"os"
 	"testing"
 	"time"
 
@@ -462,41 +463,42 @@ func TestSignatureParserOptionalParamTypesString(t *testing.T) {
 	testParser(&SignatureParser{}, in[:], []verifyFn{verifySignature(out1), verifySignature(out2)}, t)
 }
 
-func TestSignatureParserUsingProcess(t *testing.T) {
-	var in = "AutomorphismGroupSolubleGroup;"
-
-	var out1 = &Signature{
-		Intrinsic: "AutomorphismGroupSolubleGroup",
-		Params: []Param{
-			Param{
-				Name: "G",
-				Type: "GrpPC",
-			},
+// Signatures given by Magma for AutomorphismGroupSolubleGroup
+var solubleAutOut1 = &Signature{
+	Intrinsic: "AutomorphismGroupSolubleGroup",
+	Params: []Param{
+		Param{
+			Name: "G",
+			Type: "GrpPC",
 		},
-		Returns:        []string{"GrpAuto"},
-		OptionalParams: []Param{Param{Name: "p"}},
-		Comment: "Computes the automorphism group of the soluble group G, with the optional parameter 'p' which should be a " +
-			"prime dividing the order of G (the calculation relies on Aut(Syl_p(G))). Default value of p is taken to be the prime " +
-			"diving the order of G which defines the largest Sylow p-subgroup.",
-	}
+	},
+	Returns:        []string{"GrpAuto"},
+	OptionalParams: []Param{Param{Name: "p"}},
+	Comment: "Computes the automorphism group of the soluble group G, with the optional parameter 'p' which should be a " +
+		"prime dividing the order of G (the calculation relies on Aut(Syl_p(G))). Default value of p is taken to be the prime " +
+		"diving the order of G which defines the largest Sylow p-subgroup.",
+}
 
-	var out2 = &Signature{
-		Intrinsic: "AutomorphismGroupSolubleGroup",
-		Params: []Param{
-			Param{
-				Name: "G",
-				Type: "GrpPC",
-			},
-			Param{
-				Name: "p",
-				Type: "RngIntElt",
-			},
+var solubleAutOut2 = &Signature{
+	Intrinsic: "AutomorphismGroupSolubleGroup",
+	Params: []Param{
+		Param{
+			Name: "G",
+			Type: "GrpPC",
 		},
-		Returns:        []string{"GrpAuto"},
-		OptionalParams: []Param{},
-		Comment: "Computes the automorphism group of the soluble group G using the automorphism group of a Sylow p-subgroup of G. " +
-			"Setting p to 1 is equivalent to calling AutomorphismGroupSolubleGroup(G).",
-	}
+		Param{
+			Name: "p",
+			Type: "RngIntElt",
+		},
+	},
+	Returns:        []string{"GrpAuto"},
+	OptionalParams: []Param{},
+	Comment: "Computes the automorphism group of the soluble group G using the automorphism group of a Sylow p-subgroup of G. " +
+		"Setting p to 1 is equivalent to calling AutomorphismGroupSolubleGroup(G).",
+}
+
+func TestSignatureParserUsingProcess(t *testing.T) {
+	var in = "AutomorphismGroupSolubleGroup;"
 
 	// Create a new process and start it
 	testSignatureParser := func(p *proc.Process, st <-chan proc.Tagged, so *proc.Output) error {
@@ -523,7 +525,7 @@ func TestSignatureParserUsingProcess(t *testing.T) {
 		sp := &SignatureParser{}
 		ch := sp.Run(out)
 
-		testChannelOutput(ch, []verifyFn{verifySignature(out1), verifySignature(out2)}, t)
+		testChannelOutput(ch, []verifyFn{verifySignature(solubleAutOut1), verifySignature(solubleAutOut2)}, t)
 
 		qch, err := p.Quit()
 		checkErrorf(t, "Quit() error: %v", err)
@@ -541,3 +543,28 @@ func TestSignatureParserUsingProcess(t *testing.T) {
 	err := proc.Launch(&proc.Process{}, testSignatureParser)
 	checkErrorf(t, "Launch() error: %v", err)
 }
+
+func TestSignatureParserUsingTranscript(t *testing.T) {
+	f, err := os.Open("testdata/signature.transcript")
+	checkFatalf(t, "Open() error: %v", err)
+	defer f.Close()
+
+	command := "AutomorphismGroupSolubleGroup;"
+	tested := false
+	err = proc.Replay(f, func(o *proc.Output) error {
+		if o.Command() != command {
+			return nil
+		}
+		tested = true
+
+		sp := &SignatureParser{}
+		ch := sp.Run(o.Output())
+		testChannelOutput(ch, []verifyFn{verifySignature(solubleAutOut1), verifySignature(solubleAutOut2)}, t)
+		return nil
+	})
+	checkErrorf(t, "Replay() error: %v", err)
+
+	if !tested {
+		t.Errorf("transcript did not contain command: %v", command)
+	}
+}
@@ -77,12 +77,12 @@ func TestTracebackParserString(t *testing.T) {
 
 	var out1 = &Traceback{Index: NoIndex,
 		Name:   "Test2",
-		Params: []ParamValue{ParamValue{"x", "0"}},
+		Params: []ParamValue{ParamValue{Name: "x", Value: "0"}},
 	}
 
 	var out2 = &Traceback{Index: NoIndex,
 		Name:   "Test",
-		Params: []ParamValue{ParamValue{"x", "0"}},
+		Params: []ParamValue{ParamValue{Name: "x", Value: "0"}},
 	}
 
 	testParser(&TracebackParser{}, in[:], []verifyFn{verifyTraceback(out1), verifyTraceback(out2)}, t)
@@ -103,14 +103,14 @@ func TestTracebackParserString2(t *testing.T) {
 
 	var out1 = &Traceback{Index: NoIndex,
 		Name:   "AutomorphismGroupSolubleGroup",
-		Params: []ParamValue{ParamValue{"G", "GrpPC"}},
+		Params: []ParamValue{ParamValue{Name: "G", Value: "GrpPC"}},
 	}
 
 	var out2 = &Traceback{Index: NoIndex,
 		Name: "FixSubgroup",
 		Params: []ParamValue{
-			ParamValue{"A", "A group of automorphisms of GrpPC"},
-			ParamValue{"H", "GrpPC : H"}},
+			ParamValue{Name: "A", Value: "A group of automorphisms of GrpPC"},
+			ParamValue{Name: "H", Value: "GrpPC : H"}},
 	}
 
 	testParser(&TracebackParser{}, in[:], []verifyFn{verifyTraceback(out1), verifyTraceback(out2)}, t)
@@ -144,14 +144,14 @@ func TestTracebackParserStringIndexMarkerLocation(t *testing.T) {
 	var out1 = &Traceback{Index: 0,
 		Current:  true,
 		Name:     "Test",
-		Params:   []ParamValue{ParamValue{"x", "0"}},
+		Params:   []ParamValue{ParamValue{Name: "x", Value: "0"}},
 		Location: Location{File: "<main>", Row: 2},
 	}
 
 	var out2 = &Traceback{Index: 1,
 		Current:  false,
 		Name:     "Test2",
-		Params:   []ParamValue{ParamValue{"x", "0"}},
+		Params:   []ParamValue{ParamValue{Name: "x", Value: "0"}},
 		Location: Location{File: "<main>", Row: 3},
 	}
 
@@ -174,15 +174,15 @@ func TestTracebackParserStringIndexMarkerLocation2(t *testing.T) {
 		Current: true,
 		Name:    "FixSubgroup",
 		Params: []ParamValue{
-			ParamValue{"A", "A group of automorphisms of GrpPC"},
-			ParamValue{"H", "GrpPC : H"}},
+			ParamValue{Name: "A", Value: "A group of automorphisms of GrpPC"},
+			ParamValue{Name: "H", Value: "GrpPC : H"}},
 		Location: Location{File: "/Users/dave/git/magma/Prog/package/Group/GrpPC/aut/fix-subgroup.m", Row: 363},
 	}
 
 	var out2 = &Traceback{Index: 1,
 		Current:  false,
 		Name:     "AutomorphismGroupSolubleGroup",
-		Params:   []ParamValue{ParamValue{"G", "GrpPC"}},
+		Params:   []ParamValue{ParamValue{Name: "G", Value: "GrpPC"}},
 		Location: Location{File: "/Users/dave/git/magma/Prog/package/Group/GrpPC/aut/aut.m", Row: 713},
 	}
 
@@ -0,0 +1,435 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultPoolRestartDelay is the time waited between failed attempts to start
+// a replacement worker process in a Pool.
+const DefaultPoolRestartDelay = time.Second
+
+// healthCheckCommand is executed by health checks on idle pool workers.
+const healthCheckCommand = "1;"
+
+// Pool maintains a set of started Magma processes (workers) which are handed
+// out to run commands.  Workers are replaced when their process exits, and are
+// recycled (i.e. quit and replaced) after a given number of commands or after
+// a command gives an internal error.
+//
+// Pool values are exported to allow for some pre-start configuration.
+type Pool struct {
+	// Size is the number of worker processes in the pool.
+	Size int
+
+	// New (optional) returns a new (not started) Process for the pool.  Workers
+	// without a ReadHandler fail all read requests (see FailReads), as nothing
+	// would answer them.
+	//
+	// If nil, workers are created using &Process{}.
+	New func() *Process
+
+	// Init (optional) is executed on each worker process after it starts,
+	// before it is used to run any other commands.  If it fails then the
+	// worker is killed and is not started.
+	Init string
+
+	// MaxStatements (optional) is the number of commands run on a worker before
+	// it is recycled.  If zero, workers are only recycled after internal errors.
+	MaxStatements int
+
+	// Instrumentation (optional) receives measurements of the pool, and of
+	// each worker process which does not have its own Instrumentation.
+	Instrumentation Instrumentation
+
+	// HealthCheckInterval (optional) specifies how often idle workers are checked
+	// by executing a trivial command.  Workers which do not respond within the
+	// interval are killed and replaced.  If zero, no health checks are run.
+	HealthCheckInterval time.Duration
+
+	idle chan *worker // Workers which are ready for use
+
+	mu       sync.Mutex
+	workers  map[*worker]bool // All workers with running processes
+	starting int              // Number of workers being started
+	waiting  int              // Number of Execute calls waiting for a worker
+	closed   bool
+	done     chan struct{} // Closed by Close
+	wg       sync.WaitGroup
+}
+
+// worker is a process in a Pool.
+type worker struct {
+	p          *Process
+	statements int
+	exited     chan struct{} // Closed when the process has exited
+}
+
+// ready returns true if the worker's process is ready for input (and not,
+// for instance, left waiting for input to a read statement).
+func (w *worker) ready() bool {
+	s, _ := w.p.State()
+	return s == StateReady
+}
+
+// PoolStats gives the current state of a Pool.
+type PoolStats struct {
+	Size     int // Number of running worker processes
+	Busy     int // Number of workers running commands
+	Idle     int // Number of workers ready for use
+	Starting int // Number of workers being started (or replaced)
+	Queued   int // Number of Execute calls waiting for a worker
+}
+
+// Start starts the worker processes of the pool, returning an error if any
+// fail to start (in which case any which did start are closed).
+func (pl *Pool) Start() error {
+	if pl.Size < 1 {
+		return errors.New("magma/proc: pool size must be at least 1")
+	}
+
+	pl.mu.Lock()
+	if pl.idle != nil {
+		pl.mu.Unlock()
+		return errors.New("magma/proc: pool has already been started")
+	}
+	pl.idle = make(chan *worker, pl.Size)
+	pl.workers = make(map[*worker]bool)
+	pl.done = make(chan struct{})
+	pl.mu.Unlock()
+
+	for i := 0; i < pl.Size; i++ {
+		pl.mu.Lock()
+		pl.starting++
+		pl.mu.Unlock()
+
+		if err := pl.startWorker(); err != nil {
+			pl.mu.Lock()
+			pl.starting--
+			pl.mu.Unlock()
+			pl.Close()
+			return err
+		}
+	}
+
+	if pl.HealthCheckInterval > 0 {
+		go pl.healthChecks()
+	}
+	return nil
+}
+
+// startWorker starts a new worker process and adds it to the idle workers.
+// The caller must have incremented pl.starting.
+func (pl *Pool) startWorker() error {
+	p := &Process{}
+	if pl.New != nil {
+		p = pl.New()
+	}
+	if p.ReadHandler == nil {
+		p.ReadHandler = FailReads
+	}
+	if p.Instrumentation == nil {
+		p.Instrumentation = pl.Instrumentation
+	}
+
+	so, err := p.Start()
+	if err != nil {
+		return err
+	}
+	drainOutput(so)
+
+	w := &worker{p: p, exited: make(chan struct{})}
+	pl.wg.Add(1)
+	go pl.monitor(w)
+
+	if pl.Init != "" {
+		res, err := p.Run(pl.Init)
+		if err == nil {
+			err = res.Err()
+		}
+		if err != nil {
+			p.Kill()
+			<-w.exited
+			return fmt.Errorf("magma/proc: running Init: %v", err)
+		}
+	}
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.starting--
+	if pl.closed {
+		pl.retire(w)
+		return nil
+	}
+	pl.workers[w] = true
+	pl.idle <- w
+	return nil
+}
+
+// monitor waits for the worker process to exit, and starts a replacement.
+func (pl *Pool) monitor(w *worker) {
+	defer pl.wg.Done()
+	w.p.Wait()
+	close(w.exited)
+
+	pl.mu.Lock()
+	live := pl.workers[w]
+	delete(pl.workers, w)
+	pl.removeIdle(w)
+	replace := live && !pl.closed
+	if replace {
+		pl.starting++
+	}
+	pl.mu.Unlock()
+
+	if replace {
+		pl.instrumentation().WorkerRestarted()
+		pl.wg.Add(1)
+		go pl.replace()
+	}
+}
+
+// removeIdle removes w from the idle workers.  Must be called with pl.mu held.
+func (pl *Pool) removeIdle(w *worker) {
+	for n := len(pl.idle); n > 0; n-- {
+		select {
+		case x := <-pl.idle:
+			if x != w {
+				pl.idle <- x
+			}
+		default:
+			return
+		}
+	}
+}
+
+// replace starts a replacement worker, retrying until it succeeds or the
+// pool is closed.
+func (pl *Pool) replace() {
+	defer pl.wg.Done()
+	for {
+		err := pl.startWorker()
+		if err == nil {
+			return
+		}
+
+		select {
+		case <-pl.done:
+			pl.mu.Lock()
+			pl.starting--
+			pl.mu.Unlock()
+			return
+		case <-time.After(DefaultPoolRestartDelay):
+		}
+	}
+}
+
+// retire ends the worker process.  The monitor goroutine for the worker will
+// start a replacement if required.
+func (pl *Pool) retire(w *worker) {
+	go func() {
+		if _, err := w.p.Quit(); err != nil {
+			w.p.Kill()
+		}
+	}()
+}
+
+// instrumentation returns pl.Instrumentation, or an Instrumentation which does
+// nothing if it is not set.
+func (pl *Pool) instrumentation() Instrumentation {
+	if pl.Instrumentation == nil {
+		return nopInstrumentation{}
+	}
+	return pl.Instrumentation
+}
+
+// acquire waits for an idle worker.
+func (pl *Pool) acquire(ctx context.Context) (*worker, error) {
+	pl.mu.Lock()
+	if pl.idle == nil || pl.closed {
+		pl.mu.Unlock()
+		return nil, errors.New("magma/proc: pool is not running")
+	}
+	pl.waiting++
+	pl.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		pl.mu.Lock()
+		pl.waiting--
+		pl.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case w := <-pl.idle:
+			select {
+			case <-w.exited:
+				// Process has exited and will be replaced
+				continue
+			default:
+			}
+			pl.instrumentation().QueueWait(time.Since(start))
+			return w, nil
+		case <-pl.done:
+			return nil, errors.New("magma/proc: pool has been closed")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release returns the worker to the pool once the output o (which may be
+// nil) is complete, recycling it if required.
+func (pl *Pool) release(w *worker, o *Output) {
+	if o != nil {
+		select {
+		case <-o.done:
+		case <-w.exited:
+		}
+	}
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if o != nil {
+		w.statements++
+	}
+	switch {
+	case !pl.workers[w]:
+		// Process has exited
+	case pl.closed,
+		o != nil && o.internalError,
+		!w.ready(),
+		pl.MaxStatements > 0 && w.statements >= pl.MaxStatements:
+		pl.retire(w)
+	default:
+		pl.idle <- w
+	}
+}
+
+// Execute runs the given command on an idle worker, waiting for one to become
+// available if necessary.  See Process.Execute.
+func (pl *Pool) Execute(s string) (*Output, error) {
+	return pl.ExecuteContext(context.Background(), s)
+}
+
+// ExecuteContext is like Execute but respects the given context, both whilst
+// waiting for a worker and running the command (see Process.ExecuteContext).
+func (pl *Pool) ExecuteContext(ctx context.Context, s string) (*Output, error) {
+	w, err := pl.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := w.p.ExecuteContext(ctx, s)
+	if err != nil {
+		go pl.release(w, nil)
+		return nil, err
+	}
+	go pl.release(w, o)
+	return o, nil
+}
+
+// healthChecks periodically checks idle workers until the pool is closed.
+func (pl *Pool) healthChecks() {
+	t := time.NewTicker(pl.HealthCheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-pl.done:
+			return
+		}
+
+		for n := len(pl.idle); n > 0; n-- {
+			var w *worker
+			select {
+			case w = <-pl.idle:
+			default:
+			}
+			if w == nil {
+				break
+			}
+			pl.check(w)
+		}
+	}
+}
+
+// check runs a health check on the (idle) worker w, killing the process if
+// it fails (the monitor goroutine then starts a replacement).
+func (pl *Pool) check(w *worker) {
+	ctx, cancel := context.WithTimeout(context.Background(), pl.HealthCheckInterval)
+	defer cancel()
+
+	o, err := w.p.ExecuteContext(ctx, healthCheckCommand)
+	if err == nil {
+		drainOutput(o)
+	}
+	if err != nil || ctx.Err() != nil {
+		w.p.Kill()
+		return
+	}
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if pl.workers[w] && !pl.closed {
+		pl.idle <- w
+		return
+	}
+	pl.retire(w)
+}
+
+// Stats returns the current state of the pool.
+func (pl *Pool) Stats() PoolStats {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	s := PoolStats{
+		Size:     len(pl.workers),
+		Idle:     len(pl.idle),
+		Starting: pl.starting,
+		Queued:   pl.waiting,
+	}
+	s.Busy = s.Size - s.Idle
+	return s
+}
+
+// Close quits all the worker processes in the pool, waiting for any running
+// commands to complete.
+func (pl *Pool) Close() error {
+	pl.mu.Lock()
+	if pl.idle == nil {
+		pl.mu.Unlock()
+		return errors.New("magma/proc: pool not started")
+	}
+	if pl.closed {
+		pl.mu.Unlock()
+		return errors.New("magma/proc: pool has already been closed")
+	}
+	pl.closed = true
+	close(pl.done)
+
+	for {
+		var w *worker
+		select {
+		case w = <-pl.idle:
+		default:
+		}
+		if w == nil {
+			break
+		}
+		pl.retire(w)
+	}
+	pl.mu.Unlock()
+
+	pl.wg.Wait()
+	return nil
+}
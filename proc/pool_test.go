@@ -0,0 +1,251 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForStats polls pl.Stats() until f returns true, or fails the test after
+// a timeout.
+func waitForStats(t *testing.T, pl *Pool, f func(PoolStats) bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s := pl.Stats()
+		if f(s) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for pool stats, got: %+v", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// poolProcesses returns a function for Pool.New which records each Process
+// it creates.
+func poolProcesses(new func() *Process) (func() *Process, func() []*Process) {
+	var mu sync.Mutex
+	var ps []*Process
+	return func() *Process {
+			p := new()
+			mu.Lock()
+			ps = append(ps, p)
+			mu.Unlock()
+			return p
+		}, func() []*Process {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]*Process(nil), ps...)
+		}
+}
+
+func TestPoolExecute(t *testing.T) {
+	pl := &Pool{Size: 2, New: newTestProcess, Init: "a := 1;"}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	s := pl.Stats()
+	if s.Size != 2 || s.Idle != 2 || s.Busy != 0 {
+		t.Errorf("unexpected stats after Start(): %+v", s)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o, err := pl.Execute("print a;")
+			if err != nil {
+				t.Errorf("Execute() error: %v", err)
+				return
+			}
+			lines := collectLines(o)
+			if len(lines) != 1 || lines[0] != "1" {
+				t.Errorf("expected output [1], got: %v", lines)
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitForStats(t, pl, func(s PoolStats) bool { return s.Idle == 2 })
+}
+
+func TestPoolMaxStatements(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	pl := &Pool{Size: 1, New: new, MaxStatements: 2}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	for i := 0; i < 3; i++ {
+		o, err := pl.Execute("1;")
+		checkFatalf(t, "Execute() error: %v", err)
+		Discard(o.Output())
+	}
+
+	waitForStats(t, pl, func(s PoolStats) bool { return s.Idle == 1 })
+	if n := len(processes()); n != 2 {
+		t.Errorf("expected 2 processes to be started, got %d", n)
+	}
+}
+
+func TestPoolInternalError(t *testing.T) {
+	new, processes := poolProcesses(newFakeProcess)
+	pl := &Pool{Size: 1, New: new}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	o, err := pl.Execute("ei();")
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+
+	waitForStats(t, pl, func(s PoolStats) bool { return s.Idle == 1 && len(processes()) == 2 })
+}
+
+func TestPoolReplaceExited(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	pl := &Pool{Size: 2, New: new}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	checkFatalf(t, "Kill() error: %v", processes()[0].Kill())
+
+	waitForStats(t, pl, func(s PoolStats) bool { return s.Idle == 2 && len(processes()) == 3 })
+
+	o, err := pl.Execute("1;")
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+}
+
+func TestPoolExecuteContext(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	pl := &Pool{Size: 1, New: new}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	o, err := pl.Execute("while i lt 1 do print i; end while;")
+	checkFatalf(t, "Execute() error: %v", err)
+	done := make(chan struct{})
+	go func() {
+		Discard(o.Output())
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pl.ExecuteContext(ctx, "1;")
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded waiting for worker, got: %v", err)
+	}
+	if s := pl.Stats(); s.Busy != 1 || s.Queued != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	_, err = processes()[0].InterruptExecution()
+	checkFatalf(t, "InterruptExecution() error: %v", err)
+	<-done
+}
+
+func TestPoolErrors(t *testing.T) {
+	pl := &Pool{}
+	if err := pl.Start(); err == nil {
+		t.Errorf("expected error starting pool of size 0")
+	}
+
+	pl = &Pool{Size: 1}
+	if _, err := pl.Execute("1;"); err == nil {
+		t.Errorf("expected error from Execute() before Start()")
+	}
+	if err := pl.Close(); err == nil {
+		t.Errorf("expected error from Close() before Start()")
+	}
+
+	pl = &Pool{Size: 1, New: newTestProcess}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	checkErrorf(t, "Close() error: %v", pl.Close())
+	if _, err := pl.Execute("1;"); err == nil {
+		t.Errorf("expected error from Execute() after Close()")
+	}
+	if err := pl.Close(); err == nil {
+		t.Errorf("expected error from second Close()")
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	pl := &Pool{Size: 2, New: newTestProcess, HealthCheckInterval: 20 * time.Millisecond}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		o, err := pl.Execute("1;")
+		checkFatalf(t, "Execute() error: %v", err)
+		Discard(o.Output())
+	}
+	waitForStats(t, pl, func(s PoolStats) bool { return s.Size == 2 && s.Starting == 0 })
+}
+
+func TestPoolHealthCheckFailed(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	pl := &Pool{Size: 1, New: new}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	// Take the worker as a health check would, and fail the check
+	w := <-pl.idle
+	pl.HealthCheckInterval = time.Nanosecond
+	pl.check(w)
+
+	select {
+	case x := <-pl.idle:
+		if x == w {
+			t.Errorf("expected killed worker not to be returned to the pool")
+		}
+		pl.idle <- x
+	default:
+	}
+	<-w.exited
+	waitForStats(t, pl, func(s PoolStats) bool { return s.Size == 1 && s.Starting == 0 })
+	if n := len(processes()); n != 2 {
+		t.Errorf("expected 2 processes, got %d", n)
+	}
+}
+
+func TestPoolInitError(t *testing.T) {
+	pl := &Pool{Size: 1, New: newTestProcess, Init: "print undefined;"}
+	if err := pl.Start(); err == nil {
+		pl.Close()
+		t.Errorf("expected Start() error")
+	}
+}
+
+func TestPoolCloseDuringHealthCheck(t *testing.T) {
+	pl := &Pool{Size: 1, New: newFakeProcess, HealthCheckInterval: time.Hour}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+
+	// Take the worker as a health check would, then close the pool before
+	// the check completes
+	w := <-pl.idle
+	done := make(chan error, 1)
+	go func() {
+		done <- pl.Close()
+	}()
+	waitForStats(t, pl, func(PoolStats) bool {
+		pl.mu.Lock()
+		defer pl.mu.Unlock()
+		return pl.closed
+	})
+	pl.check(w)
+
+	select {
+	case err := <-done:
+		checkErrorf(t, "Close() error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close() did not return after health check")
+	}
+}
@@ -7,12 +7,15 @@ package proc
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Default command and arguments for Magma processes.
@@ -21,6 +24,10 @@ const (
 	DefaultArgs           = "-x -n -b"
 )
 
+// DefaultInterruptGrace is the time allowed for Magma to acknowledge an
+// interrupt sent on context cancellation before the process is killed.
+const DefaultInterruptGrace = 5 * time.Second
+
 // Process represents a Magma process being prepared or run.
 // Command, Env and Args values are exported to allow for some
 // pre-start configuration.
@@ -39,42 +46,179 @@ type Process struct {
 	Env []string
 
 	// Args gives extra arguments for command. These are appended
-	// to the default set of arguments given in DefaultArgs.
+	// to the default set of arguments given in DefaultArgs (or those
+	// given by Options).
 	Args []string
 
-	startUp  chan struct{}     // Closed if there is a problem with startup
-	ready    chan chan *Output // Notify when process is ready for input
-	response chan *Output
-	writer   chan io.Writer // Channel for passing around the io.Writer for Magma stdin
-	status   chan Tagged    // Channel providing status messages
-	errch    chan error     // Channel passing errors back from goroutines
-	cmd      *exec.Cmd      // Input used to start process
+	// Options (optional) gives typed launch options for the process.  If set,
+	// the options are validated by Start and used in place of DefaultArgs, and
+	// to set the working directory and environment (with Env as the base
+	// environment, see Options.Environ).
+	Options *Options
+
+	// Timeout (optional) is the time allowed for each command passed to
+	// Execute to complete, after which it is interrupted (see ExecuteContext).
+	// If zero, commands are not timed out.
+	Timeout time.Duration
+
+	// InterruptGrace (optional) is the time allowed for each step in the
+	// escalation of an interrupt sent because a command timed out or the
+	// context passed to ExecuteContext was done: the first interrupt, the
+	// second interrupt (which makes Magma quit), and finally killing the
+	// process.
+	//
+	// If zero, DefaultInterruptGrace is used.
+	InterruptGrace time.Duration
+
+	// Transcript (optional) receives a record of all the raw input written
+	// to, and output read from, the Magma process (see Replay).
+	Transcript io.Writer
+
+	// MaxOutput (optional) is the maximum number of bytes of rendered output
+	// collected by Run, after which output is discarded.  If zero, output is
+	// not limited.
+	MaxOutput int
+
+	// Stderr (optional) receives the output written by the Magma process to
+	// stderr.  Output is dropped rather than blocking Magma if Stderr falls
+	// behind.  The most recent stderr output is also retained by the Process
+	// (see StderrTail), and attached to errors returned by Start and Wait.
+	Stderr io.Writer
+
+	// StderrSize (optional) is the number of bytes of stderr output retained.
+	//
+	// If zero, DefaultStderrSize is used.
+	StderrSize int
+
+	// OutputBuffer (optional) buffers the output of each command so that the
+	// processing of Magma output is not held up by the consumer.  Up to
+	// OutputBuffer bytes of line data is held in memory, after which it is
+	// spilled to a temporary file in SpillDir (removed once the output has
+	// been read).  The output of each command must still be read to
+	// completion (or discarded).
+	//
+	// If zero, output is unbuffered and must be read as it is produced.
+	OutputBuffer int
+
+	// SpillDir (optional) is the directory for files holding spilled output.
+	//
+	// If empty, the default directory for temporary files is used.
+	SpillDir string
+
+	// MaxLineLength (optional) is the maximum length in bytes of a line of
+	// Magma output.  Longer lines are truncated, and marked as such (see
+	// Line.Truncated).
+	//
+	// If zero, DefaultMaxLineLength is used, and if negative then the length
+	// of lines is not limited.
+	MaxLineLength int
+
+	// LineChunkSize (optional) splits the data of long output lines into
+	// Lines of at most LineChunkSize bytes, with all but the first marked as
+	// continuations.  If zero, lines are not split.
+	LineChunkSize int
+
+	// InputPolicy determines how commands passed to Execute (and input for
+	// read statements) which contain ^D or a stray byte 129 once encoded are
+	// handled (see InputPolicy), as these would corrupt the framing of the
+	// protocol.  By default such commands
+	// are rejected with an *InvalidInputError.  Input for read statements
+	// cannot be rejected, and so is stripped of invalid characters instead.
+	InputPolicy InputPolicy
+
+	// Encoding is the character encoding used for input to, and output from,
+	// Magma.  Output is always decoded to valid UTF-8 (see EncodingAuto).
+	Encoding Encoding
+
+	// ReadHandler (optional) answers the read and readi statements run by the
+	// process, in place of passing ReadRequests in the output.  Processes
+	// which run unattended should set a ReadHandler (such as FailReads) so
+	// that read statements cannot block forever.
+	ReadHandler ReadHandler
+
+	// MaxReadRetries (optional) is the number of times a readi request is
+	// retried after its answer is rejected as not being an integer, after
+	// which the statement is interrupted (see ReadError).
+	//
+	// If zero, DefaultMaxReadRetries is used.
+	MaxReadRetries int
+
+	// Instrumentation (optional) receives measurements of the commands run
+	// by the process.
+	Instrumentation Instrumentation
+
+	// Limits (optional) gives resource limits for the process, which are
+	// set (using ulimit in /bin/sh) before Magma is executed.  The reason for
+	// the process ending (including any exceeded limit) is given by Exit.
+	// Only supported on Linux.
+	Limits *Limits
+
+	// ProcessGroup runs the process in its own process group, so that Kill
+	// also reaches any children started by Magma (for instance by System or
+	// Pipe).  Only supported on Linux.
+	ProcessGroup bool
+
+	startUp    chan struct{}     // Closed if there is a problem with startup
+	ready      chan chan *Output // Notify when process is ready for input
+	debugReady chan chan *Output // Notify when the debugger is ready for a command
+	response   chan *Output
+	writer     chan io.Writer // Channel for passing around the io.Writer for Magma stdin
+	errch      chan error     // Channel passing errors back from goroutines
+	exited     chan struct{}  // Closed when the output parser has finished
+	cmd        *exec.Cmd      // Input used to start process
+
+	transcript *transcript   // Records communication (if Transcript is set)
+	stderr     *ringBuffer   // Most recent stderr output
+	stderrDone chan struct{} // Closed when stderr has been read to EOF and forwarded
+	exitMu     sync.Mutex    // Guards exit
+	exit       *Exit         // How the process ended (set by Wait)
+
+	started  chan struct{} // Closed when the first RDY tag is received
+	untagged []string      // Untagged output lines received before the first RDY tag
+
+	lastRead   *ReadRequest // Most recently answered read request
+	lastAnswer string       // Answer to lastRead
+	readRetry  *ReadRequest // Read request to retry after an RDI_ER tag
+
+	optionsDone chan struct{} // Closed when the statements applying Options have run
+	optionsErr  error         // Error from applying Options (set before optionsDone is closed)
+
+	seeds  seedLog // Random state at the start of the most recent statement
+	replay bool    // Output is replayed from a transcript (see Replay)
 
 	interrupt chan chan struct{} // Pass interrupt channel to parser to acknowledge INT tag
 	quit      chan chan struct{} // Pass quit channel to parser to acknowledge QUIT tag
-}
 
-// StatusTags returns the status channel for this process. Should
-// be called before Start(). If not called, then status output is
-// discarded.
-func (p *Process) StatusTags() (<-chan Tagged, error) {
-	if p.status != nil {
-		return nil, errors.New("cannot call StatusTags() after Start()")
-	}
-	p.status = make(chan Tagged)
-	return p.status, nil
+	sm     stateMachine // State of the session
+	events bus          // Status and lifecycle events
 }
 
-func (p *Process) setupDefaultStatusHandler() {
-	p.status = make(chan Tagged)
+// StatusTags returns a channel which receives the Ready and Status tags from
+// the process, and is closed when the process output has ended.  The channel
+// is unbuffered, and the processing of Magma output is blocked until each tag
+// is received.
+//
+// Deprecated: Use Subscribe, which allows for many subscribers and does not
+// need to block.
+func (p *Process) StatusTags() (<-chan Tagged, error) {
+	s := p.Subscribe(0, PolicyBlock)
+	ch := make(chan Tagged)
 	go func() {
-		for _ = range p.status {
+		defer close(ch)
+		for e := range s.C {
+			switch e.Kind {
+			case EventStatus:
+				ch <- e.Tag
+			case EventExited:
+				s.Close()
+			}
 		}
 	}()
+	return ch, nil
 }
 
 func (p *Process) setupStdoutHandler(stdout io.Reader) {
-	ch := make(chan []byte)
+	ch := make(chan rawLine)
 	stop := make(chan struct{})
 
 	go func() {
@@ -82,33 +226,69 @@ func (p *Process) setupStdoutHandler(stdout io.Reader) {
 		if _, ok := <-p.startUp; ok {
 			err = p.parseStdoutLines(ch)
 		}
-		p.errch <- err
 		if err != nil {
 			p.Kill()
 		}
 		close(stop)
+
+		// The parser has finished, so no further state changes can follow
+		p.sm.set(StateExited)
+		p.events.publish(EventExited, nil)
+		close(p.exited)
+		p.errch <- err
 	}()
 
+	max := p.MaxLineLength
+	if max == 0 {
+		max = DefaultMaxLineLength
+	}
+
 	go func() {
-		s := bufio.NewScanner(stdout)
-		for s.Scan() {
-			b := s.Bytes()
-			l := make([]byte, len(b))
-			copy(l, b)
+		r := bufio.NewReader(stdout)
+		var err error
+		for {
+			var l rawLine
+			l, err = readLine(r, max)
+			if err != nil {
+				break
+			}
+			if p.transcript != nil {
+				kind := transcriptOutput
+				if l.truncated {
+					kind = transcriptTruncated
+				}
+				p.transcript.record(kind, l.data)
+			}
 			select {
-			case ch <- b:
+			case ch <- l:
 			case <-stop:
 				break
 			}
 		}
 		close(ch)
-		p.errch <- s.Err()
+		if err == io.EOF {
+			err = nil
+		}
+		p.errch <- err
 	}()
 }
 
+// newOutput returns a new Output for the command s (sent to Magma as the
+// encoded command sent), buffered if p.OutputBuffer is set.
+func (p *Process) newOutput(s, sent string) *Output {
+	o := newOutput(s)
+	o.sent, o.enc = sent, p.Encoding
+	if p.OutputBuffer > 0 {
+		o.buffer(p.OutputBuffer, p.SpillDir)
+	}
+	return o
+}
+
 // Start launches a Magma process using p.Command (or DefaultCommand by default)
 // and COMMAND_ARDS + p.Args.  Any enviroment variables set in p.Env are
-// set for the process.
+// set for the process.  Once Magma is ready for input, the statements which
+// apply p.Options (see Options.Statements) are run before any command, and any
+// error from them is returned by ReadStartup and ExecuteContext.
 // Returns an output channel which passes back any startup output.
 func (p *Process) Start() (*Output, error) {
 	exe := DefaultCommand
@@ -116,66 +296,145 @@ func (p *Process) Start() (*Output, error) {
 		exe = p.Command
 	}
 	args := strings.Fields(DefaultArgs)
+	if p.Options != nil {
+		if err := p.Options.Validate(); err != nil {
+			return nil, err
+		}
+		args = p.Options.Args()
+	}
 	if p.Args != nil {
 		args = append(args, p.Args...)
 	}
 
 	p.cmd = exec.Command(exe, args...)
 	p.cmd.Env = p.Env
+	if p.Options != nil {
+		p.cmd.Env = p.Options.Environ(p.Env)
+		p.cmd.Dir = p.Options.Dir
+	}
+	p.setExit(nil)
+	if err := p.setSysProcAttr(); err != nil {
+		return nil, err
+	}
+	p.setLimits()
 
 	stdout, err := p.cmd.StdoutPipe()
 	if err != nil {
 		return nil, fmt.Errorf("stdoutpipe setup: %v", err)
 	}
 
-	stdin, err := p.cmd.StdinPipe()
+	var stdin io.Writer
+	stdin, err = p.cmd.StdinPipe()
 	if err != nil {
 		return nil, fmt.Errorf("stdinpipe setup: %v", err)
 	}
 
-	p.startUp = make(chan struct{})
-
-	p.ready = make(chan chan *Output, 1)
-	p.response = make(chan *Output, 1)
-
-	p.interrupt = make(chan chan struct{}, 1)
-	p.quit = make(chan chan struct{}, 1)
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stderrpipe setup: %v", err)
+	}
 
-	p.errch = make(chan error, 2)
-	p.setupStdoutHandler(stdout)
+	size := p.StderrSize
+	if size == 0 {
+		size = DefaultStderrSize
+	}
+	p.stderr = newRingBuffer(size)
+	p.stderrDone = make(chan struct{})
 
-	p.writer = make(chan io.Writer, 1)
-	p.writer <- stdin
+	p.transcript = nil
+	if p.Transcript != nil {
+		p.transcript = newTranscript(p.Transcript)
+		stdin = &transcriptWriter{w: stdin, t: p.transcript}
+	}
 
-	if p.status == nil {
-		p.setupDefaultStatusHandler()
+	p.startUp = make(chan struct{})
+	p.initSession(stdin)
+	var optionStmts string
+	if p.Options != nil {
+		optionStmts = p.Options.Statements()
 	}
+	p.optionsDone = make(chan struct{})
+	p.optionsErr = nil
+	p.setupStdoutHandler(stdout)
 
 	if err := p.cmd.Start(); err != nil {
-		err := fmt.Errorf("starting command: %v", err)
+		err := p.stderrError(fmt.Errorf("starting command: %v", err))
 
 		<-p.writer
 		close(p.writer)
 
 		close(p.startUp)
+		close(p.optionsDone)
 
 		close(p.ready)
+		close(p.debugReady)
 		close(p.response)
-		close(p.status)
 
 		close(p.interrupt)
 		close(p.quit)
 
+		// The output parser (waiting on startUp) publishes EventExited
 		p.cmd = nil
 		return nil, err
 	}
 
+	p.sm.set(StateStarting)
+	go p.copyStderr(stderr)
+	go p.applyOptions(optionStmts)
+
 	p.startUp <- struct{}{}
 	close(p.startUp)
 
 	return <-p.response, nil
 }
 
+// initSession creates the channels and resets the state used by a session,
+// with w receiving the input for Magma.
+func (p *Process) initSession(w io.Writer) {
+	p.ready = make(chan chan *Output, 1)
+	p.debugReady = make(chan chan *Output, 1)
+	p.response = make(chan *Output, 1)
+
+	p.interrupt = make(chan chan struct{}, 1)
+	p.quit = make(chan chan struct{}, 1)
+
+	p.errch = make(chan error, 2)
+	p.exited = make(chan struct{})
+	p.started = make(chan struct{})
+	p.untagged = nil
+	p.lastRead, p.readRetry = nil, nil
+	p.seeds.set(nil)
+
+	p.writer = make(chan io.Writer, 1)
+	p.writer <- w
+}
+
+// StartContext is like Start but binds the lifetime of the Magma process to
+// the given context: if the context is done before the process exits, then
+// the process is killed.
+func (p *Process) StartContext(ctx context.Context) (*Output, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	o, err := p.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() != nil {
+		exited := p.exited
+		go func() {
+			select {
+			case <-ctx.Done():
+				p.Kill()
+			case <-exited:
+			}
+		}()
+	}
+	return o, nil
+}
+
 // Wait blocks until the the underlying Magma process has ended,
 // and returns any resulting errors.
 func (p *Process) Wait() error {
@@ -188,23 +447,29 @@ func (p *Process) Wait() error {
 		copyError = err
 	}
 
-	select {
-	case <-p.writer:
-	default:
-	}
-	close(p.writer)
-
+	// NB: p.writer is not closed, as Execute (or the parser answering a read
+	// request) may still be holding the writer and will put it back.  Writes
+	// to stdin fail once the process has exited.
 	close(p.ready)
+	close(p.debugReady)
 	close(p.response)
-	close(p.status)
 
 	close(p.interrupt)
 	close(p.quit)
 
-	if err := p.cmd.Wait(); err != nil && copyError == nil {
-		return err
+	p.waitStderr()
+	err := p.cmd.Wait()
+	if copyError != nil {
+		err = copyError
+	}
+	if p.cmd.ProcessState != nil {
+		e := newExit(p.cmd.ProcessState, p.Limits)
+		p.setExit(e)
+		if e.Reason != ExitNormal {
+			err = &ExitError{Exit: e, Err: err}
+		}
 	}
-	return copyError
+	return p.stderrError(err)
 }
 
 // Getpid returns the process id of the underlying Magma process.  Returns
@@ -227,31 +492,114 @@ func (p *Process) checkRunning() error {
 	return nil
 }
 
-// Execute passes the given command to the Magma process.
-// Subsequent output is given via returned (unbuffered) channel.  The
-// channel is closed when the command output is complete (i.e. when
-// a RDY tag is received).
+// hasExited reports whether the output of the process has ended.
+func (p *Process) hasExited() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// Execute passes the given command to the Magma process, after checking it
+// for invalid characters according to p.InputPolicy.  Subsequent output is
+// given via returned channel (unbuffered unless p.OutputBuffer is set).  The
+// channel is closed when the command output is complete (i.e. when a RDY tag
+// is received).
 func (p *Process) Execute(s string) (*Output, error) {
+	return p.ExecuteContext(context.Background(), s)
+}
+
+// ExecuteContext is like Execute but respects the given context.  If the
+// context is done before the process is ready for input then the command
+// is not sent.  If the context is done (or p.Timeout elapses) whilst the
+// command is running, then the running statement is interrupted, escalating
+// to a second interrupt and then killing the process if Magma does not
+// respond within p.InterruptGrace (see Output.Outcome).
+//
+// Commands are not sent until the statements which apply p.Options have run
+// (see Process.Start), and any error from them is returned.
+func (p *Process) ExecuteContext(ctx context.Context, s string) (*Output, error) {
+	if err := p.checkRunning(); err != nil {
+		return nil, err
+	}
+	select {
+	case <-p.optionsDone:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if p.optionsErr != nil {
+		return nil, p.optionsErr
+	}
+	return p.execute(ctx, s)
+}
+
+// applyOptions runs the statements which apply p.Options (see
+// Options.Statements) as soon as Magma is ready for input, and then closes
+// p.optionsDone.
+func (p *Process) applyOptions(stmts string) {
+	defer close(p.optionsDone)
+	if stmts == "" {
+		return
+	}
+
+	o, err := p.execute(context.Background(), stmts)
+	if err == nil {
+		err = collectResult(o, 0).Err()
+	}
+	if err != nil {
+		p.optionsErr = fmt.Errorf("magma/proc: applying options: %v", err)
+	}
+}
+
+// execute sends the command s to Magma (see ExecuteContext).
+func (p *Process) execute(ctx context.Context, s string) (*Output, error) {
 	err := p.checkRunning()
 	if err != nil {
 		return nil, err
 	}
+	s, err = sanitizeInput(s, p.InputPolicy, false, p.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	sent, err := p.Encoding.encode(s, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Wait until the process is ready for input
-	rch, ok := <-p.ready
-	if !ok {
-		return nil, errors.New("magma/proc: Execute() called after process has completed")
+	var rch chan *Output
+	select {
+	case r, ok := <-p.ready:
+		if !ok {
+			return nil, errors.New("magma/proc: Execute() called after process has completed")
+		}
+		rch = r
+	case <-p.exited:
+		return nil, errors.New("magma/proc: Execute() called after process has exited")
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 
 	// Send the Output struct to the parser
-	rch <- newOutput(s)
+	o := p.newOutput(s, sent)
+	rch <- o
+
+	if ctx.Done() != nil || p.Timeout > 0 {
+		go p.watch(ctx, o, p.Timeout)
+	}
 
 	// Write the command to the underlying process
+	p.instrumentation().CommandStarted(s)
 	w := <-p.writer
 	defer func() {
 		p.writer <- w
 	}()
-	_, err = w.Write([]byte(s))
+	_, err = w.Write([]byte(sent))
 	if err != nil {
 		return nil, err
 	}
@@ -263,11 +611,27 @@ func (p *Process) Execute(s string) (*Output, error) {
 	}
 
 	// Wait for confirmation of running, and return output channel
-	r, ok := <-p.response
-	if !ok {
-		return nil, errors.New("magma/proc: Execute() response not returned before process completed")
+	select {
+	case r, ok := <-p.response:
+		if !ok {
+			return nil, errors.New("magma/proc: Execute() response not returned before process completed")
+		}
+		if err := ctx.Err(); err != nil {
+			Discard(r.Output())
+			return nil, err
+		}
+		return r, nil
+	case <-p.exited:
+		// The response may have been sent just before the process exited
+		select {
+		case r, ok := <-p.response:
+			if ok {
+				return r, nil
+			}
+		default:
+		}
+		return nil, errors.New("magma/proc: Execute() response not returned before process exited")
 	}
-	return r, nil
 }
 
 // Quit attempts to gracefully end the current process by sending the
@@ -287,7 +651,7 @@ func (p *Process) Quit() (<-chan struct{}, error) {
 		return nil, errors.New("magma/proc: Quit() has already been called")
 	}
 
-	o, err := p.Execute("quit;")
+	o, err := p.execute(context.Background(), "quit;")
 	if err != nil {
 		return nil, err
 	}
@@ -314,19 +678,16 @@ func (p *Process) InterruptExecution() (<-chan struct{}, error) {
 		return nil, errors.New("magma/proc: InterruptExecution() has already been called")
 	}
 
-	err = p.cmd.Process.Signal(os.Interrupt)
+	err = p.signal(os.Interrupt)
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-// Kill sends the OS kill signal to the underlying Magma process.  Returns an error
-// if the process isn't running, or the attempt fails.
+// Kill sends the OS kill signal to the underlying Magma process (and to its
+// process group if ProcessGroup is set).  Returns an error if the process
+// isn't running, or the attempt fails.
 func (p *Process) Kill() error {
-	err := p.checkRunning()
-	if err != nil {
-		return err
-	}
-	return p.cmd.Process.Signal(os.Kill)
+	return p.signal(os.Kill)
 }
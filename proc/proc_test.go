@@ -5,6 +5,7 @@
 package proc
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -71,7 +72,7 @@ func outputsEqual(a interface{}, b *Line, t errorfer) {
 }
 
 type tagged interface {
-	Tag() tag
+	Tag() Tag
 }
 
 func emptyTaggedChToLogPrintf(format string, ch <-chan Tagged) {
@@ -80,7 +81,7 @@ func emptyTaggedChToLogPrintf(format string, ch <-chan Tagged) {
 	}
 }
 
-func NewTestOutput(t tag, data string) *Line {
+func NewTestOutput(t Tag, data string) *Line {
 	return &Line{tag: t, Data: data}
 }
 
@@ -98,14 +99,14 @@ func runProcess(f processFn, t errorfer) error {
 		go emptyTaggedChToLogPrintf("Status tag received: %v", ch)
 		go f(p, t)
 	}
-	err := runCustomProcess(&Process{}, fst, t)
+	err := runCustomProcess(newTestProcess(), fst, t)
 	checkError(t, err)
 	return err
 }
 
 func runProcessWithStatus(f processWithStatusFn, t errorfer) error {
 	// Create a new process and start it
-	err := runCustomProcess(&Process{}, f, t)
+	err := runCustomProcess(newTestProcess(), f, t)
 	checkError(t, err)
 	return err
 }
@@ -417,6 +418,108 @@ func TestProcessInterruptNoRun(t *testing.T) {
 	runProcess(test, t)
 }
 
+func TestExecuteContextCancel(t *testing.T) {
+	const in = "i := 0; while i lt 1 do print i; end while;"
+
+	test := func(p *Process, t errorfer) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		log.Printf("Sending command: %v", in)
+		o, err := p.ExecuteContext(ctx, in)
+		checkFatalf(t, "ExecuteContext() error: %v", err)
+
+		done := make(chan struct{})
+		go func() {
+			emptyTaggedChToLogPrintf("Line: %v", o.Output())
+			close(done)
+		}()
+
+		// Output should complete once the interrupt has been acknowledged
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("ExecuteContext cancellation timed out")
+		}
+
+		o, err = p.Execute("1;")
+		checkErrorf(t, "Execute error: %v", err)
+		emptyTaggedChToLogPrintf("Line: %v", o.Output())
+
+		testQuitAndWait(p, t)
+	}
+	runProcess(test, t)
+}
+
+func TestExecuteContextDone(t *testing.T) {
+	test := func(p *Process, t errorfer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := p.ExecuteContext(ctx, "1;")
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled from ExecuteContext(), got: %v", err)
+		}
+
+		o, err := p.Execute("1;")
+		checkErrorf(t, "Execute error: %v", err)
+		emptyTaggedChToLogPrintf("Line: %v", o.Output())
+
+		testQuitAndWait(p, t)
+	}
+	runProcess(test, t)
+}
+
+func TestStartContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := newTestProcess()
+	so, err := p.StartContext(ctx)
+	checkFatalf(t, "StartContext() error: %v", err)
+	go emptyTaggedChToLogPrintf("Startup output: %v", so.Output())
+
+	cancel()
+
+	wch := make(chan error, 1)
+	go func() {
+		wch <- p.Wait()
+	}()
+
+	select {
+	case err := <-wch:
+		if err == nil {
+			t.Errorf("expected Wait() error after context cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Wait() timed out after context cancellation")
+	}
+}
+
+// Test executing commands whilst the process exits and Wait returns
+func TestExecuteDuringWait(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		p := newFakeProcess()
+		so, err := p.Start()
+		checkFatalf(t, "Start() error: %v", err)
+		Discard(so.Output())
+
+		wch := make(chan error, 1)
+		go func() {
+			wch <- p.Wait()
+		}()
+		checkFatalf(t, "Kill() error: %v", p.Kill())
+
+		for {
+			o, err := p.Execute("1;")
+			if err != nil {
+				break
+			}
+			Discard(o.Output())
+		}
+		<-wch
+	}
+}
+
 func TestExternalProcessInterrupt(t *testing.T) {
 	const in = "i := 0; while i lt 1 do print i; end while;"
 
@@ -612,7 +715,7 @@ func BenchmarkBasicThroughput(b *testing.B) {
 	b.StopTimer()
 
 	// Create a new process and start it
-	p := &Process{}
+	p := newTestProcess()
 	st, _ := p.StatusTags()
 	go emptyTaggedChToLogPrintf("Status tag received: %v", st)
 
@@ -0,0 +1,232 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxReadRetries is the default number of times a read request is
+// retried after its answer is rejected (see Process.MaxReadRetries).
+const DefaultMaxReadRetries = 3
+
+// ErrReadUnanswered is returned by a ReadHandler which has no answer for a
+// read request.
+var ErrReadUnanswered = errors.New("magma/proc: read request not answered")
+
+// ReadHandler answers the read requests made by read and readi statements
+// (see Process.ReadHandler).
+type ReadHandler interface {
+	// Answer returns the answer to the request r.  If an error is returned
+	// then the statement is interrupted (see ReadError).
+	Answer(r *ReadRequest) (string, error)
+}
+
+// ReadHandlerFunc is an adapter which allows a function to be used as a
+// ReadHandler.
+type ReadHandlerFunc func(r *ReadRequest) (string, error)
+
+// Answer implements ReadHandler.
+func (f ReadHandlerFunc) Answer(r *ReadRequest) (string, error) {
+	return f(r)
+}
+
+// FailReads is a ReadHandler which fails every request with ErrReadUnanswered.
+var FailReads ReadHandler = ReadHandlerFunc(func(*ReadRequest) (string, error) {
+	return "", ErrReadUnanswered
+})
+
+// ReadQueue is a ReadHandler which answers requests in turn from a queue of
+// answers, failing with ErrReadUnanswered once the queue is empty.
+type ReadQueue struct {
+	mu      sync.Mutex
+	answers []string
+}
+
+// Push adds answers to the end of the queue.
+func (q *ReadQueue) Push(answers ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.answers = append(q.answers, answers...)
+}
+
+// Len returns the number of answers in the queue.
+func (q *ReadQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.answers)
+}
+
+// Answer implements ReadHandler.
+func (q *ReadQueue) Answer(*ReadRequest) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.answers) == 0 {
+		return "", ErrReadUnanswered
+	}
+	a := q.answers[0]
+	q.answers = q.answers[1:]
+	return a, nil
+}
+
+// ReadLines returns a ReadHandler which answers each request with the next
+// line read from r, failing with ErrReadUnanswered once r is exhausted.
+func ReadLines(r io.Reader) ReadHandler {
+	var mu sync.Mutex
+	br := bufio.NewReader(r)
+	return ReadHandlerFunc(func(*ReadRequest) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		l, err := br.ReadString('\n')
+		if err == io.EOF && l != "" {
+			err = nil
+		}
+		if err == io.EOF {
+			return "", ErrReadUnanswered
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(l, "\r\n"), nil
+	})
+}
+
+// ReadCallback returns a ReadHandler which answers requests by calling f.
+// If timeout is non-zero then the context passed to f is cancelled after
+// timeout, and the request fails with context.DeadlineExceeded if f has not
+// returned.
+func ReadCallback(f func(ctx context.Context, r *ReadRequest) (string, error), timeout time.Duration) ReadHandler {
+	return ReadHandlerFunc(func(r *ReadRequest) (string, error) {
+		ctx := context.Background()
+		if timeout == 0 {
+			return f(ctx, r)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		type answer struct {
+			s   string
+			err error
+		}
+		ch := make(chan answer, 1)
+		go func() {
+			s, err := f(ctx, r)
+			ch <- answer{s, err}
+		}()
+
+		select {
+		case a := <-ch:
+			return a.s, a.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	})
+}
+
+// ReadError is the reason a command was interrupted (see Output.Outcome) when
+// its ReadHandler failed to answer a read request.
+type ReadError struct {
+	Prompt string // Prompt of the read request
+	Err    error  // Error returned by the ReadHandler
+}
+
+// Error implements error.
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("magma/proc: answering read request %q: %v", e.Prompt, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// ReadIntError describes an answer to a readi request which was not an
+// integer.  It is given as ReadRequest.Rejected when the request is retried.
+type ReadIntError struct {
+	Input   string // Rejected answer
+	Message string // Message from Magma (RDI_ER), if given
+}
+
+// Error implements error.
+func (e *ReadIntError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("magma/proc: invalid integer input %q: %v", e.Input, e.Message)
+	}
+	return fmt.Sprintf("magma/proc: invalid integer input %q", e.Input)
+}
+
+// intRegexp matches input accepted by readi.
+var intRegexp = regexp.MustCompile(`^\s*[+-]?[0-9]+\s*$`)
+
+// validateReadInt returns a *ReadIntError if s is not an integer.
+func validateReadInt(s string) error {
+	if !intRegexp.MatchString(s) {
+		return &ReadIntError{Input: s}
+	}
+	return nil
+}
+
+// answerRead returns the answer to the request r, from p.ReadHandler if set,
+// and otherwise by passing r to h.  Answers to readi requests which are not
+// integers are rejected, and the request is retried up to p.MaxReadRetries
+// times.  An error from the ReadHandler (or the last rejection) is returned
+// as a *ReadError.
+func (p *Process) answerRead(r *ReadRequest, h *rhandler) (string, error) {
+	max := p.MaxReadRetries
+	if max == 0 {
+		max = DefaultMaxReadRetries
+	}
+
+	for {
+		if r.Retries > max {
+			return "", &ReadError{Prompt: r.Prompt, Err: r.Rejected}
+		}
+
+		var answer string
+		if p.ReadHandler != nil {
+			var err error
+			answer, err = p.ReadHandler.Answer(r)
+			if err != nil {
+				return "", &ReadError{Prompt: r.Prompt, Err: err}
+			}
+		} else {
+			h.send(r)
+			select {
+			case answer = <-r.Output:
+			case err := <-r.Err:
+				return "", err
+			}
+		}
+
+		if r.tag != TagReadIntPrompt || p.replay {
+			// When replaying the answer is ignored
+			return answer, nil
+		}
+		err := validateReadInt(answer)
+		if err == nil {
+			return answer, nil
+		}
+		r = r.retry(err)
+	}
+}
+
+// abortRead interrupts the statement waiting for input after a read request
+// failed with err, which is given as the reason in the Outcome of the output.
+func (p *Process) abortRead(h *rhandler, err error) {
+	if h.r != nil {
+		h.r.esc.set(OutcomeInterrupted, err)
+	}
+	p.signal(os.Interrupt)
+}
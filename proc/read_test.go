@@ -0,0 +1,210 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runWithReadHandler starts a process with the ReadHandler h, and runs cmd.
+func runWithReadHandler(t *testing.T, h ReadHandler, cmd string) (*Process, *Result) {
+	p := newFakeProcess()
+	p.ReadHandler = h
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	res, err := p.Run(cmd)
+	checkFatalf(t, "Run() error: %v", err)
+	return p, res
+}
+
+// recordReads returns a ReadHandler which records each request, answering
+// with the given answers in turn.
+func recordReads(answers ...string) (ReadHandler, func() []*ReadRequest) {
+	var mu sync.Mutex
+	var reqs []*ReadRequest
+	h := ReadHandlerFunc(func(r *ReadRequest) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reqs = append(reqs, r)
+		if len(answers) == 0 {
+			return "", ErrReadUnanswered
+		}
+		a := answers[0]
+		answers = answers[1:]
+		return a, nil
+	})
+	return h, func() []*ReadRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return reqs
+	}
+}
+
+func TestReadQueue(t *testing.T) {
+	q := &ReadQueue{}
+	q.Push("first", "second")
+	p, res := runWithReadHandler(t, q, `read x, "x"; x; read y; y;`)
+	if res.Text() != "first\nsecond" {
+		t.Errorf("expected output %q, got %q", "first\nsecond", res.Text())
+	}
+	if q.Len() != 0 {
+		t.Errorf("expected empty queue, got Len() %d", q.Len())
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestFailReads(t *testing.T) {
+	p, res := runWithReadHandler(t, FailReads, `read x; x;`)
+	if res.Outcome != OutcomeInterrupted {
+		t.Errorf("expected outcome %v, got %v", OutcomeInterrupted, res.Outcome)
+	}
+
+	o, err := p.Execute(`read x; x;`)
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+	<-o.done
+	var re *ReadError
+	if _, reason := o.Outcome(); !errors.As(reason, &re) || !errors.Is(reason, ErrReadUnanswered) {
+		t.Errorf("expected *ReadError wrapping %v, got %v", ErrReadUnanswered, reason)
+	}
+
+	res, err = p.Run("1;")
+	checkFatalf(t, "Run() error: %v", err)
+	if res.Outcome != OutcomeCompleted {
+		t.Errorf("expected outcome %v after failed read, got %v", OutcomeCompleted, res.Outcome)
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestReadIntValidation(t *testing.T) {
+	h, reqs := recordReads("y", " 12 ")
+	p, res := runWithReadHandler(t, h, `readi x; x;`)
+	if res.Text() != "12" {
+		t.Errorf("expected output %q, got %q", "12", res.Text())
+	}
+
+	rs := reqs()
+	if len(rs) != 2 {
+		t.Fatalf("expected 2 read requests, got %d", len(rs))
+	}
+	var ie *ReadIntError
+	if rs[1].Retries != 1 || !errors.As(rs[1].Rejected, &ie) || ie.Input != "y" {
+		t.Errorf("expected retry rejecting %q, got %d retries (%v)", "y", rs[1].Retries, rs[1].Rejected)
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestReadIntRejectedByMagma(t *testing.T) {
+	big := strings.Repeat("9", 30)
+	h, reqs := recordReads(big, "3")
+	p, res := runWithReadHandler(t, h, `readi x; x;`)
+	if res.Text() != "3" {
+		t.Errorf("expected output %q, got %q", "3", res.Text())
+	}
+
+	rs := reqs()
+	if len(rs) != 2 {
+		t.Fatalf("expected 2 read requests, got %d", len(rs))
+	}
+	var ie *ReadIntError
+	if rs[1].Retries != 1 || !errors.As(rs[1].Rejected, &ie) || ie.Input != big || ie.Message == "" {
+		t.Errorf("expected retry with message from Magma, got %d retries (%v)", rs[1].Retries, rs[1].Rejected)
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestReadIntMaxRetries(t *testing.T) {
+	h, reqs := recordReads("a", "b", "c")
+	p := newFakeProcess()
+	p.ReadHandler = h
+	p.MaxReadRetries = 2
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	o, err := p.Execute(`readi x; x;`)
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+	<-o.done
+	outcome, reason := o.Outcome()
+	var ie *ReadIntError
+	if outcome != OutcomeInterrupted || !errors.As(reason, &ie) || ie.Input != "c" {
+		t.Errorf("expected outcome %v rejecting %q, got %v (%v)", OutcomeInterrupted, "c", outcome, reason)
+	}
+	if n := len(reqs()); n != 3 {
+		t.Errorf("expected 3 read requests, got %d", n)
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestReadCallbackTimeout(t *testing.T) {
+	h := ReadCallback(func(ctx context.Context, r *ReadRequest) (string, error) {
+		if r.Prompt == "fast" {
+			return "answer", nil
+		}
+		<-ctx.Done()
+		return "", ctx.Err()
+	}, 50*time.Millisecond)
+
+	a, err := h.Answer(&ReadRequest{Prompt: "fast"})
+	if a != "answer" || err != nil {
+		t.Errorf("expected Answer() = %q, nil, got %q, %v", "answer", a, err)
+	}
+
+	p := newFakeProcess()
+	p.ReadHandler = h
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	o, err := p.Execute(`read x, "slow"; x;`)
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+	<-o.done
+	if _, reason := o.Outcome(); !errors.Is(reason, context.DeadlineExceeded) {
+		t.Errorf("expected reason %v, got %v", context.DeadlineExceeded, reason)
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestReadLines(t *testing.T) {
+	h := ReadLines(strings.NewReader("a\r\nb"))
+	for _, want := range []string{"a", "b"} {
+		a, err := h.Answer(&ReadRequest{})
+		checkErrorf(t, "Answer() error: %v", err)
+		if a != want {
+			t.Errorf("expected Answer() %q, got %q", want, a)
+		}
+	}
+	if _, err := h.Answer(&ReadRequest{}); err != ErrReadUnanswered {
+		t.Errorf("expected Answer() error %v, got %v", ErrReadUnanswered, err)
+	}
+}
+
+func TestPoolFailsReads(t *testing.T) {
+	pl := &Pool{Size: 1, New: newFakeProcess}
+	checkFatalf(t, "Start() error: %v", pl.Start())
+	defer pl.Close()
+
+	o, err := pl.Execute(`read x; x;`)
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+
+	select {
+	case <-o.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for read to fail")
+	}
+	if _, reason := o.Outcome(); !errors.Is(reason, ErrReadUnanswered) {
+		t.Errorf("expected reason %v, got %v", ErrReadUnanswered, reason)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SetColumns sets the width at which Magma wraps output lines.  If n is zero
+// then output lines are not wrapped.
+func (p *Process) SetColumns(n int) error {
+	if n < 0 {
+		return errors.New("magma/proc: columns must not be negative")
+	}
+	res, err := p.Run(fmt.Sprintf("SetColumns(%d);", n))
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// Reassemble merges the output lines given on ch which were split into
+// logical lines: a Line marked as a continuation is appended to the preceding
+// Line with the same tag.  Other values are passed on unchanged.
+func Reassemble(ch <-chan Tagged) <-chan Tagged {
+	return ReassembleColumns(ch, 0)
+}
+
+// ReassembleColumns is like Reassemble, but also merges the lines wrapped by
+// Magma at the width columns (see SetColumns): a Line which fills the width
+// and ends with a backslash (as Magma uses when wrapping long integers) is
+// joined to the following Line with the same tag, and the backslash removed.
+// If columns is zero then only continuations are merged.
+func ReassembleColumns(ch <-chan Tagged, columns int) <-chan Tagged {
+	out := make(chan Tagged)
+	go func() {
+		var cur *Line
+		var wrap bool // The last Line merged into cur was wrapped
+		for x := range ch {
+			l, ok := x.(*Line)
+			if ok && cur != nil && l.tag == cur.tag && (l.Continuation || wrap) {
+				if wrap {
+					cur.Data = cur.Data[:len(cur.Data)-1]
+				}
+				cur.Data += l.Data
+				cur.Truncated = cur.Truncated || l.Truncated
+				wrap = wrapped(l, columns)
+				continue
+			}
+
+			if cur != nil {
+				out <- cur
+				cur = nil
+			}
+			if ok {
+				c := *l
+				cur = &c
+				wrap = wrapped(l, columns)
+				continue
+			}
+			out <- x
+		}
+		if cur != nil {
+			out <- cur
+		}
+		close(out)
+	}()
+	return out
+}
+
+// wrapped returns true if l has been wrapped by Magma at the width columns,
+// with a trailing backslash.
+func wrapped(l *Line, columns int) bool {
+	if columns <= 0 || !strings.HasSuffix(l.Data, `\`) {
+		return false
+	}
+	return l.Indent*len(indent)+utf8.RuneCountInString(l.Data) >= columns
+}
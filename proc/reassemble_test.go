@@ -0,0 +1,112 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reassemble passes the values in through f, returning the output.
+func reassemble(f func(<-chan Tagged) <-chan Tagged, in []Tagged) []Tagged {
+	ch := make(chan Tagged, len(in))
+	for _, x := range in {
+		ch <- x
+	}
+	close(ch)
+
+	var out []Tagged
+	for x := range f(ch) {
+		out = append(out, x)
+	}
+	return out
+}
+
+func TestReassemble(t *testing.T) {
+	in := []Tagged{
+		&Line{tag: TagOutput, Data: "abc"},
+		&Line{tag: TagOutput, Continuation: true, Data: "def"},
+		&Line{tag: TagOutput, Data: `C:\`},
+		&Line{tag: TagOutput, Data: "789"},
+		&Position{Row: 1, Column: 2},
+		&Line{tag: TagOutput, Continuation: true, Data: "x"},
+		&Line{tag: TagErrorUser, Continuation: true, Data: "y"},
+		&Line{tag: TagOutput, Indent: 2, Data: "z"},
+		&Line{tag: TagOutput, Continuation: true, Data: "z", Truncated: true},
+	}
+	expected := []Tagged{
+		&Line{tag: TagOutput, Data: "abcdef"},
+		&Line{tag: TagOutput, Data: `C:\`},
+		&Line{tag: TagOutput, Data: "789"},
+		&Position{Row: 1, Column: 2},
+		&Line{tag: TagOutput, Continuation: true, Data: "x"},
+		&Line{tag: TagErrorUser, Continuation: true, Data: "y"},
+		&Line{tag: TagOutput, Indent: 2, Data: "zz", Truncated: true},
+	}
+
+	out := reassemble(Reassemble, in)
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+	if in[0].(*Line).Data != "abc" {
+		t.Errorf("input line was modified")
+	}
+}
+
+func TestReassembleColumns(t *testing.T) {
+	in := []Tagged{
+		&Line{tag: TagOutput, Data: "123\\"},
+		&Line{tag: TagOutput, Indent: 1, Data: "4\\"},
+		&Line{tag: TagOutput, Data: "789"},
+		&Line{tag: TagOutput, Data: `C:\`},
+		&Line{tag: TagOutput, Data: "x"},
+
+		// A genuine trailing backslash on the last (short) physical line
+		&Line{tag: TagOutput, Data: "abc\\"},
+		&Line{tag: TagOutput, Data: `d\`},
+		&Line{tag: TagOutput, Data: "e"},
+	}
+	expected := []Tagged{
+		&Line{tag: TagOutput, Data: "1234789"},
+		&Line{tag: TagOutput, Data: `C:\`},
+		&Line{tag: TagOutput, Data: "x"},
+		&Line{tag: TagOutput, Data: `abcd\`},
+		&Line{tag: TagOutput, Data: "e"},
+	}
+
+	out := reassemble(func(ch <-chan Tagged) <-chan Tagged { return ReassembleColumns(ch, 4) }, in)
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v, got %v", expected, out)
+	}
+}
+
+func TestSetColumnsReassemble(t *testing.T) {
+	p := newTestProcess()
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	checkErrorf(t, "SetColumns() error: %v", p.SetColumns(0))
+	if err := p.SetColumns(-1); err == nil {
+		t.Errorf("expected SetColumns() error for negative columns")
+	}
+
+	// Lines longer than the Magma output buffer are split regardless
+	o, err := p.Execute(`print "X"^2500;`)
+	checkFatalf(t, "Execute() error: %v", err)
+	var lines []*Line
+	for x := range Reassemble(o.Output()) {
+		if l, ok := x.(*Line); ok {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) != 1 || lines[0].Data != strings.Repeat("X", 2500) {
+		t.Errorf("expected a single line of 2500 bytes, got %d lines", len(lines))
+	}
+
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
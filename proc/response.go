@@ -6,17 +6,31 @@ package proc
 
 import (
 	"strings"
+	"unicode/utf8"
 )
 
 // Output represents all the output from Magma which corresponds
 // to the execution of command string.
 type Output struct {
-	cmd string
-	ch  chan Response
+	cmd  string
+	sent string   // Command as encoded and sent to Magma
+	enc  Encoding // Encoding of sent
+	ch   chan Response
+	done chan struct{} // Closed when all responses have been sent
+
+	internalError bool         // Set if the output contains an internal error
+	esc           *escalation  // Outcome of the execution
+	q             *outputQueue // Buffers output (if set, see Process.OutputBuffer)
 }
 
 func newOutput(input string) *Output {
-	return &Output{cmd: input, ch: make(chan Response)}
+	return &Output{
+		cmd:  input,
+		sent: input,
+		ch:   make(chan Response),
+		done: make(chan struct{}),
+		esc:  &escalation{},
+	}
 }
 
 // Command returns the input command which produced this
@@ -31,7 +45,50 @@ func (o Output) Responses() <-chan Response { return o.ch }
 // Tagged output.
 func (o Output) Output() <-chan Tagged { return Combine(o.ch) }
 
-func (o Output) close() { close(o.ch) }
+// buffer queues the output, holding up to limit bytes of line data in memory
+// and spilling the rest to a temporary file in dir.
+func (o *Output) buffer(limit int, dir string) {
+	o.q = newOutputQueue(limit, dir)
+	go o.deliver()
+}
+
+// sendResponse passes r on the responses channel.
+func (o *Output) sendResponse(r responser) {
+	if o.q != nil {
+		o.q.push(queued{r: r})
+		return
+	}
+	o.ch <- r
+}
+
+// sendOutput passes t on the output channel of the response r.
+func (o *Output) sendOutput(r responser, t Tagged) {
+	if o.q != nil {
+		o.q.push(queued{t: t})
+		return
+	}
+	r.send(t)
+}
+
+// closeResponse closes the response r.
+func (o *Output) closeResponse(r responser) {
+	if o.q != nil {
+		o.q.push(queued{end: true})
+		return
+	}
+	r.close()
+}
+
+// close marks the output as complete.  If the output is buffered then the
+// responses channel is closed once all the buffered output has been read.
+func (o Output) close() {
+	if o.q != nil {
+		o.q.close()
+	} else {
+		close(o.ch)
+	}
+	close(o.done)
+}
 
 // Combine combines the output of all statements to give a single
 func Combine(ch <-chan Response) <-chan Tagged {
@@ -61,28 +118,61 @@ type chunk struct {
 	start, end Position
 }
 
+// get returns the part of input given by the chunk.  Rows are counted from
+// zero and columns are byte offsets, with the end position exclusive.  Returns
+// the empty string if the chunk is not valid for input.
 func (c chunk) get(input string) string {
-	lines := strings.Split(input, "\n")
-	if c.start.Row >= len(lines) || c.end.Row >= len(lines) {
-		return "Invalid chunk"
+	start, ok := offset(input, c.start)
+	if !ok {
+		return ""
+	}
+	end, ok := offset(input, c.end)
+	if !ok || end < start {
+		return ""
+	}
+	return input[start:end]
+}
+
+// offset returns the byte offset in input of the position p, moving forward
+// to the start of the next rune if p is within a UTF-8 encoded character.
+func offset(input string, p Position) (int, bool) {
+	if p.Row < 0 || p.Column < 0 {
+		return 0, false
+	}
+
+	i := 0
+	for r := 0; r < p.Row; r++ {
+		n := strings.IndexByte(input[i:], '\n')
+		if n < 0 {
+			return 0, false
+		}
+		i += n + 1
 	}
 
-	cmd := lines[c.start.Row][c.start.Column:]
-	for i := c.start.Row + 1; i < c.end.Row; i++ {
-		cmd += "\n" + lines[i]
+	n := strings.IndexByte(input[i:], '\n')
+	if n < 0 {
+		n = len(input) - i
 	}
-	cmd += lines[c.end.Row][:c.end.Column]
-	return cmd
+	if p.Column > n {
+		return 0, false
+	}
+	i += p.Column
+
+	for i < len(input) && !utf8.RuneStart(input[i]) {
+		i++
+	}
+	return i, true
 }
 
 func (o *Output) commandResponse(chk chunk) string {
-	return chk.get(o.cmd)
+	return string(o.enc.decode([]byte(chk.get(o.sent))))
 }
 
 // Response represents a chunk of output which corresponds to a portion
 // of the input string.
 type Response interface {
 	Command() string
+	Span() (start, end Position)
 	Output() <-chan Tagged
 }
 
@@ -94,6 +184,7 @@ type responser interface {
 
 type response struct {
 	cmd string
+	chk chunk
 	ch  chan Tagged
 }
 
@@ -104,6 +195,11 @@ func newResponse(cmd string, ch chan Tagged) *response {
 // Command returns the command string that produced this response
 func (s response) Command() string { return s.cmd }
 
+// Span returns the start and end (exclusive) positions of the command string
+// in the input which produced this response.  Rows are counted from zero, and
+// columns are byte offsets in the input as encoded for Magma.
+func (s response) Span() (start, end Position) { return s.chk.start, s.chk.end }
+
 // Line returns a <-chan Tagged which passes back the response
 // from the underlying process (line-by-line)
 func (s response) Output() <-chan Tagged { return s.ch }
@@ -146,7 +242,7 @@ type rhandler struct {
 
 func (h *rhandler) ready() bool {
 	if h.c != nil {
-		h.c.close()
+		h.r.closeResponse(h.c)
 		h.c = nil
 	}
 	if h.r != nil {
@@ -164,40 +260,55 @@ func (h *rhandler) init(e *Output) {
 	h.r = e
 }
 
-func (h *rhandler) newResponse(chk chunk, r responser) {
+// newResponseBase returns a response for the given chunk of the current input.
+func (h *rhandler) newResponseBase(chk chunk) response {
+	return response{cmd: h.r.commandResponse(chk), chk: chk, ch: make(chan Tagged)}
+}
+
+func (h *rhandler) newResponse(r responser) {
 	if h.c != nil {
-		h.c.close()
+		h.r.closeResponse(h.c)
 	}
 	h.c = r
-	h.r.ch <- h.c
+	h.r.sendResponse(h.c)
 }
 
 func (h *rhandler) start() {
-	h.newResponse(chunk{}, &response{ch: make(chan Tagged)})
+	h.newResponse(&response{ch: make(chan Tagged)})
 }
 
 func (h *rhandler) run(chk chunk, s *Seed) {
-	h.newResponse(chk, Run{
-		response: response{ch: make(chan Tagged)},
+	h.newResponse(Run{
+		response: h.newResponseBase(chk),
 		Seed:     s,
 	})
 }
 
 func (h *rhandler) parseError(chk chunk) {
-	h.newResponse(chk, ParseError{response{ch: make(chan Tagged)}})
+	h.newResponse(ParseError{h.newResponseBase(chk)})
 }
 
 // NB: we only create a new response if there isn't already one (as it may give a better
 // context for the error!)
 func (h *rhandler) internalError() {
+	if h.r != nil {
+		h.r.internalError = true
+	}
 	if h.c == nil {
-		h.newResponse(chunk{}, newInternalError())
+		h.newResponse(newInternalError())
+	}
+}
+
+// exited marks the current output as ended by the process exiting.
+func (h *rhandler) exited() {
+	if h.r != nil {
+		h.r.esc.exited()
 	}
 }
 
 func (h *rhandler) close() {
 	if h.c != nil {
-		h.c.close()
+		h.r.closeResponse(h.c)
 	}
 	if h.r != nil {
 		h.r.close()
@@ -208,5 +319,5 @@ func (h *rhandler) send(t Tagged) {
 	if h.c == nil {
 		panic("no current output")
 	}
-	h.c.send(t)
+	h.r.sendOutput(h.c, t)
 }
@@ -0,0 +1,74 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"testing"
+)
+
+func TestChunkGet(t *testing.T) {
+	tests := []struct {
+		input    string
+		chk      chunk
+		expected string
+	}{
+		{"a := 1; print a;", chunk{Position{0, 0}, Position{0, 7}}, "a := 1;"},
+		{"a := 1; print a;", chunk{Position{0, 8}, Position{0, 16}}, "print a;"},
+		{"for i in [1..2] do\n\tprint i;\nend for;", chunk{Position{0, 0}, Position{2, 8}}, "for i in [1..2] do\n\tprint i;\nend for;"},
+		{"a := 1;\nb := 2; c := 3;\n", chunk{Position{1, 8}, Position{1, 15}}, "c := 3;"},
+		{"x := \"é\"; print x;", chunk{Position{0, 0}, Position{0, 10}}, "x := \"é\";"},
+		{"x := \"é\"; print x;", chunk{Position{0, 11}, Position{0, 19}}, "print x;"},
+		{"x := \"é\"; print x;", chunk{Position{0, 7}, Position{0, 10}}, "\";"},
+		{"a;", chunk{Position{1, 0}, Position{1, 2}}, ""},
+		{"a;", chunk{Position{0, 0}, Position{0, 3}}, ""},
+		{"a;", chunk{Position{0, 2}, Position{0, 0}}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.chk.get(tt.input); got != tt.expected {
+			t.Errorf("chunk %v of %q: expected %q, got %q", tt.chk, tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestResponseSpan(t *testing.T) {
+	const input = "a := \"é\"; print a;\nprint\n  a;"
+	expected := []struct {
+		cmd        string
+		start, end Position
+	}{
+		{"a := \"é\";", Position{0, 0}, Position{0, 10}},
+		{"print a;", Position{0, 11}, Position{0, 19}},
+		{"print\n  a;", Position{1, 0}, Position{2, 4}},
+	}
+
+	test := func(p *Process, t errorfer) {
+		defer testQuitAndWait(p, t)
+
+		o, err := p.Execute(input)
+		checkFatalf(t, "Execute() error: %v", err)
+
+		i := 0
+		for r := range o.Responses() {
+			Discard(r.Output())
+			if i >= len(expected) {
+				t.Errorf("unexpected response: %q", r.Command())
+				continue
+			}
+			e := expected[i]
+			if r.Command() != e.cmd {
+				t.Errorf("expected Command() %q, got %q", e.cmd, r.Command())
+			}
+			if start, end := r.Span(); start != e.start || end != e.end {
+				t.Errorf("expected Span() %v-%v, got %v-%v", e.start, e.end, start, end)
+			}
+			i++
+		}
+		if i != len(expected) {
+			t.Errorf("expected %d responses, got %d", len(expected), i)
+		}
+	}
+	runProcess(test, t)
+}
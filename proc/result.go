@@ -0,0 +1,128 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bytes"
+)
+
+// Result is the collected output from running a command using Run.
+type Result struct {
+	Command    string       // Input command
+	Statements []*Statement // Collected output for each response to the command
+
+	// Truncated is true if the output exceeded the maximum output size, in which
+	// case any further output was discarded.
+	Truncated bool
+
+	Outcome Outcome // How the execution of the command ended (see Output.Outcome)
+}
+
+// Statement is the collected output of a single Response (i.e. a Run,
+// ParseError or InternalError) to a command.
+type Statement struct {
+	Response Response // Original response (its output has been consumed)
+	Seed     *Seed    // Random seed and step at the start of the statement (Run only)
+	Source   string   // Statement source text
+	Start    Position // Start of the statement in the input
+	End      Position // End (exclusive) of the statement in the input
+
+	Output []Tagged        // All output (other than read requests) in the order received
+	Lines  map[Tag][]*Line // Lines of output grouped by tag
+
+	text bytes.Buffer
+}
+
+// Text returns the rendered form of the statement output (see Line.WriteTo).
+func (s *Statement) Text() string {
+	return s.text.String()
+}
+
+// Text returns the rendered form of the output of all the statements.
+func (r *Result) Text() string {
+	var buf bytes.Buffer
+	for _, s := range r.Statements {
+		if buf.Len() > 0 && s.text.Len() > 0 {
+			buf.Write(nl)
+		}
+		buf.Write(s.text.Bytes())
+	}
+	return buf.String()
+}
+
+// Run executes the given command and waits for it to complete, returning all
+// the collected output.  Any read requests are given empty input (unless
+// p.ReadHandler is set), so readi statements are interrupted once their
+// retries are exhausted (see ReadError).
+//
+// If p.MaxOutput is non-zero, then once the rendered output reaches p.MaxOutput
+// bytes all further lines are discarded and the Result is marked as Truncated.
+// If the process exits before the command completes then the incomplete
+// Result is returned along with ErrExited, and similarly if output could not
+// be read back from disk (see Output.Err).
+func (p *Process) Run(s string) (*Result, error) {
+	o, err := p.Execute(s)
+	if err != nil {
+		return nil, err
+	}
+	res := collectResult(o, p.MaxOutput)
+	if res.Outcome == OutcomeExited {
+		return res, ErrExited
+	}
+	return res, o.Err()
+}
+
+// collectResult reads all the output from o, keeping at most max bytes of
+// rendered output (if max > 0).
+func collectResult(o *Output, max int) *Result {
+	res := &Result{Command: o.Command()}
+	size := 0
+	for r := range o.Responses() {
+		st := &Statement{
+			Response: r,
+			Source:   r.Command(),
+			Lines:    make(map[Tag][]*Line),
+		}
+		st.Start, st.End = r.Span()
+		if run, ok := r.(Run); ok {
+			st.Seed = run.Seed
+		}
+		res.Statements = append(res.Statements, st)
+
+		first := true
+		for x := range r.Output() {
+			switch x := x.(type) {
+			case *ReadRequest:
+				x.Output <- ""
+				continue
+
+			case *Line:
+				if res.Truncated {
+					continue
+				}
+				l := *x
+				if first {
+					// The first line of each statement begins the output
+					l.Continuation = true
+				}
+				n := len(l.Data) + l.Indent*len(indent)
+				if !l.Continuation {
+					n += len(nl)
+				}
+				if max > 0 && size+n > max {
+					res.Truncated = true
+					continue
+				}
+				size += n
+				first = false
+				l.WriteTo(&st.text)
+				st.Lines[x.tag] = append(st.Lines[x.tag], x)
+			}
+			st.Output = append(st.Output, x)
+		}
+	}
+	res.Outcome, _ = o.Outcome()
+	return res
+}
@@ -0,0 +1,91 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	test := func(p *Process, t errorfer) {
+		defer testQuitAndWait(p, t)
+
+		res, err := p.Run("1 mod 0;")
+		checkFatalf(t, "Run() error: %v", err)
+		if len(res.Statements) != 1 {
+			t.Fatalf("expected 1 statement, got %d", len(res.Statements))
+		}
+		st := res.Statements[0]
+		if _, ok := st.Response.(Run); !ok {
+			t.Errorf("expected Run response, got: %T", st.Response)
+		}
+		if st.Source != "1 mod 0;" || st.End != (Position{0, 8}) {
+			t.Errorf("unexpected statement source %q (%v-%v)", st.Source, st.Start, st.End)
+		}
+		if st.Seed == nil {
+			t.Errorf("expected Seed to be set")
+		}
+		if len(st.Lines[TagErrorRuntime]) != 1 || len(st.Lines[TagTraceback]) != 1 {
+			t.Errorf("expected ER and TB lines, got: %v", st.Lines)
+		}
+		if len(st.Output) != 3 {
+			t.Errorf("expected 3 output values (TB, POS, ER), got: %v", st.Output)
+		}
+		if !strings.HasSuffix(res.Text(), "Runtime error in 'mod': Division by zero") {
+			t.Errorf("unexpected Text(): %q", res.Text())
+		}
+
+		res, err = p.Run("p();")
+		checkFatalf(t, "Run() error: %v", err)
+		expected := "    " + strings.Repeat("X", 1025)
+		if text := res.Text(); text != expected {
+			t.Errorf("expected Text() %q, got %q", expected, text)
+		}
+		if res.Truncated {
+			t.Errorf("expected output not to be truncated")
+		}
+	}
+	runProcess(test, t)
+}
+
+func TestRunExited(t *testing.T) {
+	p := newFakeProcess()
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	_, err = p.ReadStartup(so)
+	checkFatalf(t, "ReadStartup() error: %v", err)
+
+	res, err := p.Run("crash();")
+	if err != ErrExited {
+		t.Errorf("expected ErrExited, got: %v", err)
+	}
+	if res == nil || res.Outcome != OutcomeExited {
+		t.Errorf("expected exited outcome, got: %v", res)
+	}
+	if err := p.Wait(); err == nil {
+		t.Errorf("expected Wait() error")
+	}
+}
+
+func TestRunMaxOutput(t *testing.T) {
+	p := newTestProcess()
+	p.MaxOutput = 9
+	test := func(p *Process, t errorfer, st <-chan Tagged) {
+		go emptyTaggedChToLogPrintf("Status tag received: %v", st)
+		defer testQuitAndWait(p, t)
+
+		res, err := p.Run(`for i in [1..2+1] do print "XXXX"; end for;`)
+		checkFatalf(t, "Run() error: %v", err)
+		if !res.Truncated {
+			t.Errorf("expected output to be truncated")
+		}
+		if text := res.Text(); text != "XXXX\nXXXX" {
+			t.Errorf("expected Text() %q, got %q", "XXXX\nXXXX", text)
+		}
+	}
+	err := runCustomProcess(p, test, t)
+	checkErrorf(t, "unexpected error: %v", err)
+}
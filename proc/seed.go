@@ -0,0 +1,94 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// seedLog records the random seed and step at the start of the most recent
+// statement.
+type seedLog struct {
+	mu   sync.Mutex
+	last *Seed
+}
+
+func (l *seedLog) set(s *Seed) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last = s
+}
+
+func (l *seedLog) get() *Seed {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Seed returns the random seed and step recorded at the start of the most
+// recent statement run by the process, or nil if no statement has been run.
+func (p *Process) Seed() *Seed {
+	return p.seeds.get()
+}
+
+// ReplayWithSeed runs the statement which gave the Run response r again,
+// first restoring the random seed and step it started with (using SetSeed),
+// so that any randomised computation is repeated exactly.  The statement is
+// run in the session of p: to replay it in a fresh session, use
+// ReplayWithSeedFresh.  The rest of the session state (such as variables used
+// by the statement) is not restored.
+//
+// The returned Output is that of the replayed statement alone.
+func (p *Process) ReplayWithSeed(r Response) (*Output, error) {
+	var s *Seed
+	switch r := r.(type) {
+	case Run:
+		s = r.Seed
+	case *Run:
+		s = r.Seed
+	}
+	if s == nil {
+		return nil, errors.New("magma/proc: response has no random seed")
+	}
+	if r.Command() == "" {
+		return nil, errors.New("magma/proc: response has no statement to replay")
+	}
+
+	res, err := p.Run(fmt.Sprintf("SetSeed(%d, %d);", s.Seed, s.Step))
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, fmt.Errorf("magma/proc: restoring random seed: %v", err)
+	}
+	return p.Execute(r.Command())
+}
+
+// ReplayWithSeedFresh starts p (which must not have been started) using
+// StartContext, and replays the statement which gave the Run response r in
+// its fresh session (see ReplayWithSeed).  The process is left running so
+// that its session can be inspected, and must be ended by the caller.  If
+// the replay cannot be started then the process is killed.
+func (p *Process) ReplayWithSeedFresh(ctx context.Context, r Response) (*Output, error) {
+	so, err := p.StartContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.ReadStartup(so); err != nil {
+		p.Kill()
+		p.Wait()
+		return nil, err
+	}
+	o, err := p.ReplayWithSeed(r)
+	if err != nil {
+		p.Kill()
+		p.Wait()
+		return nil, err
+	}
+	return o, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"testing"
+)
+
+// replayText replays the response r on p, returning the rendered output.
+func replayText(t *testing.T, p *Process, r Response) string {
+	o, err := p.ReplayWithSeed(r)
+	checkFatalf(t, "ReplayWithSeed() error: %v", err)
+	return collectResult(o, 0).Text()
+}
+
+func TestReplayWithSeed(t *testing.T) {
+	p := newFakeProcess()
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	if s := p.Seed(); s != nil {
+		t.Errorf("expected nil Seed() before any statement, got %+v", s)
+	}
+
+	res, err := p.Run(`SetSeed(5); Random(1000); Random(1000);`)
+	checkFatalf(t, "Run() error: %v", err)
+	if len(res.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(res.Statements))
+	}
+	st := res.Statements[2]
+	want := st.Text()
+	if s := p.Seed(); s == nil || *s != (Seed{Seed: 5, Step: 1}) {
+		t.Errorf("expected Seed() %+v, got %+v", Seed{Seed: 5, Step: 1}, s)
+	}
+
+	// Move the random state on, then replay in the current session
+	_, err = p.Run(`Random(1000); Random(1000);`)
+	checkFatalf(t, "Run() error: %v", err)
+	if got := replayText(t, p, st.Response); got != want {
+		t.Errorf("expected replayed output %q, got %q", want, got)
+	}
+
+	res, err = p.Run(`1 +;`)
+	checkFatalf(t, "Run() error: %v", err)
+	if _, err := p.ReplayWithSeed(res.Statements[0].Response); err == nil {
+		t.Errorf("expected error replaying a parse error")
+	}
+	testQuitAndWait(p, t)
+
+	// Replay in a fresh session
+	p = newFakeProcess()
+	o, err := p.ReplayWithSeedFresh(context.Background(), st.Response)
+	checkFatalf(t, "ReplayWithSeedFresh() error: %v", err)
+	if got := collectResult(o, 0).Text(); got != want {
+		t.Errorf("expected replayed output %q in fresh session, got %q", want, got)
+	}
+	testQuitAndWait(p, t)
+
+	// The process is killed if the replay fails
+	p = newFakeProcess()
+	if _, err := p.ReplayWithSeedFresh(context.Background(), res.Statements[0].Response); err == nil {
+		t.Errorf("expected error replaying a parse error in fresh session")
+	}
+	if s, _ := p.State(); s != StateExited {
+		t.Errorf("expected process to have exited, got state %v", s)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by ReadStartup when Magma exits before it is ready for input.
+var (
+	ErrLicense            = errors.New("magma/proc: Magma is not licensed")
+	ErrUnsupportedVersion = errors.New("magma/proc: Magma version does not support -x mode")
+	ErrNotXMode           = errors.New("magma/proc: Magma output is not in -x mode")
+	ErrStartup            = errors.New("magma/proc: Magma exited before it was ready for input")
+)
+
+// StartupInfo is the information given by Magma on startup.  Fields are left
+// empty if the information is not given (e.g. when the banner is suppressed).
+type StartupInfo struct {
+	Version   string   // Magma version, e.g. "V2.20-10"
+	BuildDate string   // Date shown in the startup banner
+	Seed      *uint    // Initial random seed
+	Licence   string   // User/licence line
+	Lines     []string // All startup output lines
+}
+
+var (
+	bannerRegexp  = regexp.MustCompile(`^Magma\s+(V[0-9][0-9.]*(?:-[0-9]+)?)\s+(.*?)\s*(?:on\s+\S+\s*)?\[Seed = ([0-9]+)\]`)
+	versionRegexp = regexp.MustCompile(`^Magma\s+(V[0-9][0-9.]*(?:-[0-9]+)?)`)
+
+	// Matches (lower case) errors given for unknown command line options
+	optionErrorRegexp = regexp.MustCompile(`(unknown|invalid|illegal|unrecogni[sz]ed|bad)( command line)? (option|flag|argument)`)
+)
+
+// parseStartupLines fills in the StartupInfo fields from the given lines.
+func parseStartupLines(lines []string) *StartupInfo {
+	info := &StartupInfo{Lines: lines}
+	for _, l := range lines {
+		l = strings.TrimSpace(l)
+		if m := bannerRegexp.FindStringSubmatch(l); m != nil {
+			info.Version = m[1]
+			info.BuildDate = m[2]
+			if seed, err := strconv.ParseUint(m[3], 10, 0); err == nil {
+				s := uint(seed)
+				info.Seed = &s
+			}
+			continue
+		}
+		if m := versionRegexp.FindStringSubmatch(l); m != nil && info.Version == "" {
+			info.Version = m[1]
+			continue
+		}
+		if info.Licence == "" && isLicenceLine(l) {
+			info.Licence = l
+		}
+	}
+	return info
+}
+
+func isLicenceLine(l string) bool {
+	l = strings.ToLower(l)
+	return strings.Contains(l, "licen") || strings.Contains(l, "user:")
+}
+
+// ReadStartup reads the startup output so (as returned by Start) until Magma is
+// ready for input, and returns the parsed StartupInfo.  If the Magma process
+// exits before it is ready, then the startup and stderr output is examined to
+// return a more specific error: ErrLicense, ErrUnsupportedVersion, ErrNotXMode
+// or otherwise ErrStartup (use errors.Is to test for these).  Otherwise it
+// waits for the statements applying p.Options to run, returning any error
+// from them.
+func (p *Process) ReadStartup(so *Output) (*StartupInfo, error) {
+	var lines []string
+	first := true
+	for x := range so.Output() {
+		switch x := x.(type) {
+		case *Line:
+			if x.Continuation && !first {
+				lines[len(lines)-1] += x.Data
+				continue
+			}
+			lines = append(lines, x.Data)
+			first = false
+		case *ReadRequest:
+			x.Output <- ""
+		}
+	}
+
+	select {
+	case <-p.started:
+		return p.startupApplied(lines)
+	case <-p.exited:
+	}
+
+	select {
+	case <-p.started:
+		return p.startupApplied(lines)
+	default:
+	}
+
+	info := parseStartupLines(append(p.untagged, lines...))
+	p.waitStderr()
+	return info, p.diagnoseStartup(info)
+}
+
+// startupApplied waits for the statements applying p.Options to run, and
+// returns the parsed startup lines along with any error from them.
+func (p *Process) startupApplied(lines []string) (*StartupInfo, error) {
+	<-p.optionsDone
+	return parseStartupLines(lines), p.optionsErr
+}
+
+// diagnoseStartup returns an error describing why Magma exited on startup.
+func (p *Process) diagnoseStartup(info *StartupInfo) error {
+	var all bytes.Buffer
+	for _, l := range info.Lines {
+		all.WriteString(l)
+		all.Write(nl)
+	}
+	stderr := bytes.TrimSpace(p.StderrTail())
+	all.Write(stderr)
+
+	detail := strings.TrimSpace(string(stderr))
+	if detail == "" && len(info.Lines) > 0 {
+		detail = strings.TrimSpace(info.Lines[len(info.Lines)-1])
+	}
+
+	text := strings.ToLower(all.String())
+	var err error
+	switch {
+	case strings.Contains(text, "licen"):
+		err = ErrLicense
+	case optionErrorRegexp.MatchString(text):
+		err = ErrUnsupportedVersion
+	case len(p.untagged) > 0:
+		err = ErrNotXMode
+	default:
+		err = ErrStartup
+	}
+
+	if detail == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %v", err, detail)
+}
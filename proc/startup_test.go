@@ -0,0 +1,77 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dhowden/magma/proc/magmatest"
+)
+
+func TestParseStartupLines(t *testing.T) {
+	info := parseStartupLines(fakeBanner)
+	if info.Version != "V2.20-10" {
+		t.Errorf("expected Version V2.20-10, got %q", info.Version)
+	}
+	if info.BuildDate != "Mon Oct 27 2014 10:00:00" {
+		t.Errorf("unexpected BuildDate: %q", info.BuildDate)
+	}
+	if info.Seed == nil || *info.Seed != 2849493729 {
+		t.Errorf("expected Seed 2849493729, got %v", info.Seed)
+	}
+	if info.Licence != "Licensed to: Test User" {
+		t.Errorf("unexpected Licence: %q", info.Licence)
+	}
+
+	info = parseStartupLines(nil)
+	if info.Version != "" || info.Seed != nil {
+		t.Errorf("expected empty StartupInfo, got %+v", info)
+	}
+}
+
+func TestReadStartup(t *testing.T) {
+	cmd, env := magmatest.Command(fakeBannerName)
+	p := &Process{Command: cmd, Env: env}
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+
+	info, err := p.ReadStartup(so)
+	checkFatalf(t, "ReadStartup() error: %v", err)
+	if info.Version != "V2.20-10" || len(info.Lines) != len(fakeBanner) {
+		t.Errorf("unexpected StartupInfo: %+v", info)
+	}
+
+	testQuitAndWait(p, t)
+}
+
+func TestReadStartupErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected error
+	}{
+		{fakeFailedName, ErrLicense},
+		{fakeBadOptionName, ErrUnsupportedVersion},
+		{fakeNotXModeName, ErrNotXMode},
+		{fakeSilentFailName, ErrStartup},
+	}
+
+	for _, tt := range tests {
+		cmd, env := magmatest.Command(tt.name)
+		p := &Process{Command: cmd, Env: env}
+
+		so, err := p.Start()
+		checkFatalf(t, "Start() error: %v", err)
+
+		_, err = p.ReadStartup(so)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%v: expected error %v, got: %v", tt.name, tt.expected, err)
+		}
+		if err := p.Wait(); err == nil {
+			t.Errorf("%v: expected error from Wait()", tt.name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a Magma session (see Process.State).
+type State int
+
+// States of a Magma session.
+const (
+	StateNotStarted   State = iota // Start has not been called
+	StateStarting                  // Started, waiting for the first RDY tag
+	StateReady                     // Ready for input
+	StateRunning                   // Running a command
+	StateReadingInput              // Waiting for input to a read/readi statement
+	StateDebugging                 // Stopped in the debugger (see Debugger)
+	StateInterrupted               // Execution has been interrupted
+	StateQuitting                  // Quitting
+	StateExited                    // The process output has ended
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNotStarted:
+		return "not started"
+	case StateStarting:
+		return "starting"
+	case StateReady:
+		return "ready"
+	case StateRunning:
+		return "running"
+	case StateReadingInput:
+		return "reading input"
+	case StateDebugging:
+		return "debugging"
+	case StateInterrupted:
+		return "interrupted"
+	case StateQuitting:
+		return "quitting"
+	case StateExited:
+		return "exited"
+	}
+	return "unknown"
+}
+
+// Transition is a change in the state of a Magma session.
+type Transition struct {
+	From, To State
+	Time     time.Time // Time of the transition
+}
+
+// stateMachine records the state of a session and passes transitions to
+// watchers.
+type stateMachine struct {
+	mu       sync.Mutex
+	state    State
+	since    time.Time
+	watchers map[chan Transition]bool
+}
+
+// set moves to the state s, notifying watchers if the state changed.
+// Watchers are closed once StateExited is reached.
+func (m *stateMachine) set(s State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == s {
+		return
+	}
+	t := Transition{From: m.state, To: s, Time: time.Now()}
+	m.state, m.since = s, t.Time
+
+	for ch := range m.watchers {
+		sendTransition(ch, t)
+		if s == StateExited {
+			delete(m.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// sendTransition passes t to ch without blocking, discarding the oldest pending
+// transition if ch is full so that the latest state is always received.
+func sendTransition(ch chan Transition, t Transition) {
+	for {
+		select {
+		case ch <- t:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// State returns the current state of the Magma session, and the time at which
+// it was entered.
+func (p *Process) State() (State, time.Time) {
+	p.sm.mu.Lock()
+	defer p.sm.mu.Unlock()
+	return p.sm.state, p.sm.since
+}
+
+// Transitions returns a channel which receives the state transitions of the
+// Magma session, and a function which stops them being sent and closes the
+// channel.  The channel is closed after the transition to StateExited.
+//
+// Transitions are never blocked by a slow receiver: if the buffer (of size
+// at least 1) is full then the oldest transition is discarded.
+func (p *Process) Transitions(buffer int) (<-chan Transition, func()) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan Transition, buffer)
+
+	p.sm.mu.Lock()
+	defer p.sm.mu.Unlock()
+	if p.sm.state == StateExited {
+		close(ch)
+		return ch, func() {}
+	}
+	if p.sm.watchers == nil {
+		p.sm.watchers = make(map[chan Transition]bool)
+	}
+	p.sm.watchers[ch] = true
+
+	return ch, func() {
+		p.sm.mu.Lock()
+		defer p.sm.mu.Unlock()
+		if p.sm.watchers[ch] {
+			delete(p.sm.watchers, ch)
+			close(ch)
+		}
+	}
+}
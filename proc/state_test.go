@@ -0,0 +1,139 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateTransitions(t *testing.T) {
+	p := newTestProcess()
+	if s, _ := p.State(); s != StateNotStarted {
+		t.Errorf("expected State() %v before Start(), got %v", StateNotStarted, s)
+	}
+	ch, _ := p.Transitions(32)
+	stopped, stop := p.Transitions(1)
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	_, err = p.ReadStartup(so)
+	checkFatalf(t, "ReadStartup() error: %v", err)
+	if s, _ := p.State(); s != StateReady {
+		t.Errorf("expected State() %v, got %v", StateReady, s)
+	}
+
+	stop()
+	stop()
+	for _ = range stopped {
+	}
+
+	o, err := p.Execute(`read x, "prompt";`)
+	checkFatalf(t, "Execute() error: %v", err)
+	for x := range o.Output() {
+		if r, ok := x.(*ReadRequest); ok {
+			if s, _ := p.State(); s != StateReadingInput {
+				t.Errorf("expected State() %v, got %v", StateReadingInput, s)
+			}
+			r.Output <- "input"
+		}
+	}
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+
+	var states []State
+	prev := Transition{From: StateNotStarted, To: StateNotStarted}
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case tr, ok := <-ch:
+			if !ok {
+				done = true
+				break
+			}
+			if tr.From != prev.To || tr.Time.Before(prev.Time) {
+				t.Errorf("transition %+v does not follow %+v", tr, prev)
+			}
+			states = append(states, tr.To)
+			prev = tr
+		case <-timeout:
+			t.Fatalf("timed out waiting for transitions channel to be closed")
+		}
+	}
+
+	expected := []State{
+		StateStarting, StateReady,
+		StateRunning, StateReadingInput, StateRunning, StateReady,
+		StateRunning, StateQuitting, StateExited,
+	}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected transitions to %v, got %v", expected, states)
+	}
+
+	if s, since := p.State(); s != StateExited || !since.Equal(prev.Time) {
+		t.Errorf("expected State() %v at %v, got %v at %v", StateExited, prev.Time, s, since)
+	}
+	if ch, _ := p.Transitions(1); !isClosed(ch) {
+		t.Errorf("expected closed channel from Transitions() after exit")
+	}
+}
+
+// isClosed returns true if ch is closed (and empty).
+func isClosed(ch <-chan Transition) bool {
+	select {
+	case _, ok := <-ch:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func TestStateTransitionsDiscardOldest(t *testing.T) {
+	var m stateMachine
+	ch := make(chan Transition, 2)
+	m.watchers = map[chan Transition]bool{ch: true}
+
+	m.set(StateStarting)
+	m.set(StateReady)
+	m.set(StateRunning)
+	m.set(StateRunning)
+
+	if tr := <-ch; tr.To != StateReady {
+		t.Errorf("expected transition to %v, got %v", StateReady, tr.To)
+	}
+	if tr := <-ch; tr.To != StateRunning {
+		t.Errorf("expected transition to %v, got %v", StateRunning, tr.To)
+	}
+	select {
+	case tr := <-ch:
+		t.Errorf("unexpected transition %+v", tr)
+	default:
+	}
+}
+
+// Test that the state is StateExited once Wait has returned
+func TestStateExitedAfterWait(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		p := newFakeProcess()
+		so, err := p.Start()
+		checkFatalf(t, "Start() error: %v", err)
+		Discard(so.Output())
+		tch, _ := p.Transitions(10)
+
+		testQuitAndWait(p, t)
+		p.Wait()
+		if s, _ := p.State(); s != StateExited {
+			t.Fatalf("expected state %v after Wait(), got %v", StateExited, s)
+		}
+		var last Transition
+		for tr := range tch {
+			last = tr
+		}
+		if last.To != StateExited {
+			t.Fatalf("expected final transition to %v, got %v", StateExited, last.To)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStderrSize is the number of bytes of Magma stderr output retained
+// by a Process (see Process.StderrSize).
+const DefaultStderrSize = 4096
+
+// StderrError is returned by Start and Wait when Magma has written to stderr,
+// and gives the most recent stderr output along with the underlying error.
+type StderrError struct {
+	Err    error  // Underlying error
+	Stderr []byte // Most recent stderr output
+}
+
+// Error implements error.
+func (e *StderrError) Error() string {
+	return fmt.Sprintf("%v (stderr: %v)", e.Err, strings.TrimSpace(string(e.Stderr)))
+}
+
+// Unwrap returns the underlying error.
+func (e *StderrError) Unwrap() error {
+	return e.Err
+}
+
+// ringBuffer is an io.Writer which retains the last size bytes written to it.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (r *ringBuffer) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(b)
+	if n >= r.size {
+		r.buf = append(r.buf[:0], b[n-r.size:]...)
+		return n, nil
+	}
+	if drop := len(r.buf) + n - r.size; drop > 0 {
+		r.buf = r.buf[:copy(r.buf, r.buf[drop:])]
+	}
+	r.buf = append(r.buf, b...)
+	return n, nil
+}
+
+// Bytes returns a copy of the retained bytes.
+func (r *ringBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.buf...)
+}
+
+// StderrTail returns the most recent output written by the Magma process to
+// stderr (at most p.StderrSize bytes).
+func (p *Process) StderrTail() []byte {
+	if p.stderr == nil {
+		return nil
+	}
+	return p.stderr.Bytes()
+}
+
+// stderrQueue is the number of reads from Magma stderr which are queued to be
+// written to Process.Stderr, after which further output is dropped.
+const stderrQueue = 64
+
+// stderrWait is the time allowed for Magma stderr to be read to EOF once the
+// process has exited (a child of Magma may hold stderr open).
+const stderrWait = time.Second
+
+// copyStderr reads r (Magma stderr) until EOF, retaining the most recent output
+// and passing it on to p.Stderr (if set).  Writes to p.Stderr are queued, and
+// output is dropped if the queue is full or a write fails, so that Magma is
+// never blocked writing to stderr by a slow p.Stderr.
+func (p *Process) copyStderr(r io.Reader) {
+	defer close(p.stderrDone)
+
+	var q chan []byte
+	done := make(chan struct{})
+	if p.Stderr != nil {
+		q = make(chan []byte, stderrQueue)
+		go forwardStderr(p.Stderr, q, done)
+	} else {
+		close(done)
+	}
+
+	b := make([]byte, 1024)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			p.stderr.Write(b[:n])
+			if q != nil {
+				select {
+				case q <- append([]byte(nil), b[:n]...):
+				default:
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if q != nil {
+		close(q)
+	}
+	<-done
+}
+
+// forwardStderr writes the output received from q to w until q is closed (or
+// a write fails), and then closes done.
+func forwardStderr(w io.Writer, q <-chan []byte, done chan<- struct{}) {
+	defer close(done)
+	for b := range q {
+		if _, err := w.Write(b); err != nil {
+			break
+		}
+	}
+	for range q {
+	}
+}
+
+// waitStderr waits for stderr to be copied (see copyStderr) for at most
+// stderrWait.
+func (p *Process) waitStderr() {
+	t := time.NewTimer(stderrWait)
+	defer t.Stop()
+	select {
+	case <-p.stderrDone:
+	case <-t.C:
+	}
+}
+
+// stderrError attaches the most recent stderr output to err (if there is any).
+func (p *Process) stderrError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if b := p.StderrTail(); len(b) > 0 {
+		return &StderrError{Err: err, Stderr: b}
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhowden/magma/proc/magmatest"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := newRingBuffer(5)
+	tests := []struct {
+		in, expected string
+	}{
+		{"ab", "ab"},
+		{"cde", "abcde"},
+		{"f", "bcdef"},
+		{"ghijklm", "ijklm"},
+		{"", "ijklm"},
+	}
+
+	for _, tt := range tests {
+		r.Write([]byte(tt.in))
+		if got := string(r.Bytes()); got != tt.expected {
+			t.Errorf("after writing %q expected %q, got %q", tt.in, tt.expected, got)
+		}
+	}
+}
+
+func TestStderrError(t *testing.T) {
+	cmd, env := magmatest.Command(fakeFailedName)
+	var buf bytes.Buffer
+	p := &Process{Command: cmd, Env: env, Stderr: &buf}
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	err = p.Wait()
+	var e *StderrError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *StderrError from Wait(), got: %v", err)
+	}
+	if string(e.Stderr) != fakeFailedStderr {
+		t.Errorf("expected Stderr %q, got %q", fakeFailedStderr, e.Stderr)
+	}
+	if !strings.Contains(e.Error(), strings.TrimSpace(fakeFailedStderr)) {
+		t.Errorf("expected Error() to contain stderr output, got: %q", e.Error())
+	}
+	if buf.String() != fakeFailedStderr {
+		t.Errorf("expected Stderr writer to receive %q, got %q", fakeFailedStderr, buf.String())
+	}
+	if string(p.StderrTail()) != fakeFailedStderr {
+		t.Errorf("expected StderrTail() %q, got %q", fakeFailedStderr, p.StderrTail())
+	}
+}
+
+// blockedWriter is an io.Writer which blocks until unblock is closed.
+type blockedWriter struct {
+	unblock chan struct{}
+}
+
+func (w blockedWriter) Write(b []byte) (int, error) {
+	<-w.unblock
+	return len(b), nil
+}
+
+func TestStderrSlowWriter(t *testing.T) {
+	w := blockedWriter{unblock: make(chan struct{})}
+	defer close(w.unblock)
+
+	p := newFakeProcess()
+	p.Stderr = w
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	res, err := p.Run(`System("head -c 262144 /dev/zero >&2");`)
+	checkFatalf(t, "Run() error: %v", err)
+	checkErrorf(t, "unexpected error: %v", res.Err())
+	if n := len(p.StderrTail()); n != DefaultStderrSize {
+		t.Errorf("expected StderrTail() of %d bytes, got %d", DefaultStderrSize, n)
+	}
+
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
+
+func TestStderrHeldOpen(t *testing.T) {
+	p := newFakeProcess()
+	p.Stderr = io.Discard
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	o, err := p.Execute(`System("sleep 60 &"); quit;`)
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+
+	done := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stderrWait + 5*time.Second):
+		t.Errorf("Wait() blocked whilst stderr was held open")
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultMaxRestarts is the number of consecutive restarts after which a
+// Supervisor gives up (see Supervisor.MaxRestarts).
+const DefaultMaxRestarts = 5
+
+// ErrRestartLimit is returned by Supervisor.Run once the supervised process
+// has been restarted too many times without a command completing.
+var ErrRestartLimit = errors.New("magma/proc: supervised process restarted too many times")
+
+// Supervisor runs commands on a Magma process, restarting the process if it
+// exits and replaying all the statements which previously completed without
+// error, so that the session state is restored.  Restarted processes use the
+// same options and the same initial random seed as the original.
+//
+// Supervisor values are exported to allow for some pre-start configuration.
+type Supervisor struct {
+	// New (optional) returns a new (not started) Process.
+	//
+	// If nil, processes are created using &Process{}.
+	New func() *Process
+
+	// OnRestart (optional) is called after each restart with a report of the
+	// statements which were replayed.
+	OnRestart func(r *RestartReport)
+
+	// MaxRestarts (optional) is the number of consecutive restarts (without a
+	// command completing in between) after which the supervisor gives up and
+	// Run returns ErrRestartLimit.  If zero, DefaultMaxRestarts is used.
+	MaxRestarts int
+
+	// Instrumentation (optional) receives the restarts and replays of the
+	// supervisor, and measurements of each process which does not have its
+	// own Instrumentation.
+	Instrumentation Instrumentation
+
+	mu       sync.Mutex
+	cur      *supervised // Current process (nil if not running)
+	err      error       // Error from the last exit or restart
+	log      []string    // Statements which completed without error
+	restarts int         // Consecutive restarts
+	seed     *uint       // Initial random seed of the session
+	started  bool
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// supervised is a process started by a Supervisor.
+type supervised struct {
+	p      *Process
+	exited chan struct{} // Closed when the process has exited
+	err    error         // Error returned by Wait (set before exited is closed)
+}
+
+// RestartReport describes the restart of a supervised Magma process.
+type RestartReport struct {
+	Err      error           // Error from the process which exited (if any)
+	Replayed []string        // Statements which were replayed successfully
+	Failed   []ReplayFailure // Statements which failed on replay
+}
+
+// ReplayFailure is a statement which failed when replayed after a restart.
+type ReplayFailure struct {
+	Statement string
+	Err       error
+}
+
+// Start starts the supervised process.
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return errors.New("magma/proc: supervisor has already been started")
+	}
+	s.started = true
+	return s.start()
+}
+
+// start starts a new process, must be called with s.mu held.
+func (s *Supervisor) start() error {
+	p := &Process{}
+	if s.New != nil {
+		p = s.New()
+	}
+	if p.Instrumentation == nil {
+		p.Instrumentation = s.Instrumentation
+	}
+	if s.seed != nil {
+		o := Options{}
+		if p.Options != nil {
+			o = *p.Options
+		}
+		seed := *s.seed
+		o.Seed = &seed
+		p.Options = &o
+	}
+
+	so, err := p.Start()
+	if err != nil {
+		return err
+	}
+	c := &supervised{p: p, exited: make(chan struct{})}
+	s.wg.Add(1)
+	go s.monitor(c)
+
+	if _, err := p.ReadStartup(so); err != nil {
+		<-c.exited
+		return err
+	}
+	if s.seed == nil {
+		if err := s.captureSeed(p); err != nil {
+			p.Kill()
+			<-c.exited
+			return err
+		}
+	}
+	s.cur = c
+	return nil
+}
+
+// captureSeed records the initial random seed of the session of p, must be
+// called with s.mu held.
+func (s *Supervisor) captureSeed(p *Process) error {
+	if p.Options != nil && p.Options.Seed != nil {
+		seed := *p.Options.Seed
+		s.seed = &seed
+		return nil
+	}
+	// GetSeed does not advance the random state; the seed is taken from
+	// the RUN tag
+	if _, err := p.Run("GetSeed();"); err != nil {
+		return err
+	}
+	if sd := p.Seed(); sd != nil {
+		seed := sd.Seed
+		s.seed = &seed
+	}
+	return nil
+}
+
+// monitor waits for the process to exit, and restarts it (unless the
+// supervisor has been closed).
+func (s *Supervisor) monitor(c *supervised) {
+	defer s.wg.Done()
+	c.err = c.p.Wait()
+	close(c.exited)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur != c {
+		// Exited during startup, or already handled by Run
+		return
+	}
+	s.cur = nil
+	s.err = c.err
+	if s.closed {
+		return
+	}
+	s.restart()
+}
+
+// restart starts a new process and replays the statement log, must be
+// called with s.mu held.  A statement which causes the process to exit on
+// replay is dropped from the log, and the process is restarted again.
+func (s *Supervisor) restart() error {
+	r := &RestartReport{Err: s.err}
+	inst := s.instrumentation()
+	log := s.log
+	for s.cur == nil {
+		if s.restarts >= s.maxRestarts() {
+			s.err = ErrRestartLimit
+			return s.err
+		}
+		s.restarts++
+		if err := s.start(); err != nil {
+			s.err = err
+			return err
+		}
+		inst.WorkerRestarted()
+		s.log = nil
+		log = s.replay(log, r)
+	}
+	inst.Replayed(len(r.Replayed), len(r.Failed))
+
+	if s.OnRestart != nil {
+		s.OnRestart(r)
+	}
+	return nil
+}
+
+// replay runs the statements from log on the current process, recording those
+// which complete without error.  If the process exits then the statement
+// being run is dropped, and the statements to replay on the next process
+// are returned.
+func (s *Supervisor) replay(log []string, r *RestartReport) []string {
+	c := s.cur
+	for i, stmt := range log {
+		res, err := c.p.Run(stmt)
+		if err == nil {
+			err = res.Err()
+		}
+		if err != nil {
+			r.Failed = append(r.Failed, ReplayFailure{Statement: stmt, Err: err})
+			if s.exited(c, err) {
+				return append(s.log, log[i+1:]...)
+			}
+			continue
+		}
+		r.Replayed = append(r.Replayed, stmt)
+		s.log = append(s.log, stmt)
+	}
+	return nil
+}
+
+// exited reports whether err (from running a command on c) was caused by the
+// process exiting, in which case it waits for the exit and clears the current
+// process.  Must be called with s.mu held.
+func (s *Supervisor) exited(c *supervised, err error) bool {
+	if err != ErrExited && !c.p.hasExited() {
+		return false
+	}
+	<-c.exited
+	s.cur = nil
+	s.err = c.err
+	return true
+}
+
+// maxRestarts returns s.MaxRestarts, or DefaultMaxRestarts if it is not set.
+func (s *Supervisor) maxRestarts() int {
+	if s.MaxRestarts == 0 {
+		return DefaultMaxRestarts
+	}
+	return s.MaxRestarts
+}
+
+// instrumentation returns s.Instrumentation, or an Instrumentation which does
+// nothing if it is not set.
+func (s *Supervisor) instrumentation() Instrumentation {
+	if s.Instrumentation == nil {
+		return nopInstrumentation{}
+	}
+	return s.Instrumentation
+}
+
+// Run runs the command on the supervised process (see Process.Run), first
+// restarting the process if it is not running.  Statements which complete
+// without error are recorded to be replayed after a restart.
+//
+// If the process exits whilst running the command then it is restarted, and
+// ErrExited is returned along with any partial Result.  The command is not
+// run again, nor is it recorded for replay.  Once the process has been
+// restarted s.MaxRestarts times without a command completing, Run returns
+// ErrRestartLimit.
+func (s *Supervisor) Run(cmd string) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started || s.closed {
+		return nil, errors.New("magma/proc: supervisor is not running")
+	}
+	if s.cur == nil {
+		if err := s.restart(); err != nil {
+			return nil, err
+		}
+	}
+
+	c := s.cur
+	res, err := c.p.Run(cmd)
+	if err != nil {
+		if s.exited(c, err) {
+			s.restart()
+		}
+		return res, err
+	}
+	s.restarts = 0
+
+	for _, st := range res.Statements {
+		if _, ok := st.Response.(Run); !ok || st.Source == "" || st.Err() != nil {
+			continue
+		}
+		s.log = append(s.log, st.Source)
+	}
+	return res, nil
+}
+
+// Statements returns the statements which will be replayed after a restart.
+func (s *Supervisor) Statements() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.log...)
+}
+
+// Close quits the supervised process and waits for it to exit.
+func (s *Supervisor) Close() error {
+	s.mu.Lock()
+	if !s.started || s.closed {
+		s.mu.Unlock()
+		return errors.New("magma/proc: supervisor is not running")
+	}
+	s.closed = true
+	c := s.cur
+	s.mu.Unlock()
+
+	if c != nil {
+		if _, err := c.p.Quit(); err != nil {
+			c.p.Kill()
+		}
+		<-c.exited
+	}
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSupervisorRestart(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	reports := make(chan *RestartReport, 1)
+	s := &Supervisor{New: new, OnRestart: func(r *RestartReport) { reports <- r }}
+	checkFatalf(t, "Start() error: %v", s.Start())
+	defer s.Close()
+
+	for _, cmd := range []string{"a := 5;", "print b;", "c := a; print c;"} {
+		_, err := s.Run(cmd)
+		checkFatalf(t, "Run() error: %v", err)
+	}
+
+	expected := []string{"a := 5;", "c := a;", "print c;"}
+	if stmts := s.Statements(); !reflect.DeepEqual(stmts, expected) {
+		t.Errorf("expected Statements() %v, got %v", expected, stmts)
+	}
+
+	checkFatalf(t, "Kill() error: %v", processes()[0].Kill())
+
+	select {
+	case r := <-reports:
+		if !reflect.DeepEqual(r.Replayed, expected) || len(r.Failed) != 0 {
+			t.Errorf("unexpected RestartReport: %+v", r)
+		}
+		if r.Err == nil {
+			t.Errorf("expected RestartReport.Err to be set")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for restart")
+	}
+
+	res, err := s.Run("print c;")
+	checkFatalf(t, "Run() error: %v", err)
+	if text := res.Text(); text != "5" {
+		t.Errorf("expected output 5 after restart, got %q", text)
+	}
+
+	ps := processes()
+	if len(ps) != 2 {
+		t.Fatalf("expected 2 processes, got %d", len(ps))
+	}
+	if o := ps[1].Options; o == nil || o.Seed == nil || *o.Seed != 1 {
+		t.Errorf("expected restarted process to have seed 1, got: %+v", o)
+	}
+}
+
+func TestSupervisorReplayFailure(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	s := &Supervisor{New: new}
+	checkFatalf(t, "Start() error: %v", s.Start())
+
+	s.mu.Lock()
+	s.log = []string{"a := 1;", "print undefined;"}
+	s.mu.Unlock()
+
+	checkFatalf(t, "Kill() error: %v", processes()[0].Kill())
+
+	// Run fails (and restarts the process) if the monitor has not already
+	// restarted the process
+	res, err := s.Run("print a;")
+	if err != nil {
+		res, err = s.Run("print a;")
+	}
+	checkFatalf(t, "Run() error: %v", err)
+	if text := res.Text(); text != "1" {
+		t.Errorf("expected output 1, got %q", text)
+	}
+	expected := []string{"a := 1;", "print a;"}
+	if stmts := s.Statements(); !reflect.DeepEqual(stmts, expected) {
+		t.Errorf("expected Statements() %v, got %v", expected, stmts)
+	}
+
+	checkErrorf(t, "Close() error: %v", s.Close())
+	if _, err := s.Run("1;"); err == nil {
+		t.Errorf("expected error from Run() after Close()")
+	}
+}
+
+func TestSupervisorExited(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	s := &Supervisor{New: new}
+	checkFatalf(t, "Start() error: %v", s.Start())
+	defer s.Close()
+
+	_, err := s.Run("a := 1;")
+	checkFatalf(t, "Run() error: %v", err)
+	if _, err := s.Run("crash();"); err != ErrExited {
+		t.Fatalf("expected ErrExited, got: %v", err)
+	}
+	expected := []string{"a := 1;"}
+	if stmts := s.Statements(); !reflect.DeepEqual(stmts, expected) {
+		t.Errorf("expected Statements() %v, got %v", expected, stmts)
+	}
+
+	res, err := s.Run("print a;")
+	checkFatalf(t, "Run() error: %v", err)
+	if text := res.Text(); text != "1" {
+		t.Errorf("expected output 1, got %q", text)
+	}
+	if n := len(processes()); n != 2 {
+		t.Errorf("expected 2 processes, got %d", n)
+	}
+}
+
+func TestSupervisorRestartLimit(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	s := &Supervisor{New: new, MaxRestarts: 2}
+	checkFatalf(t, "Start() error: %v", s.Start())
+	defer s.Close()
+
+	s.mu.Lock()
+	s.log = []string{"crash();", "crash();", "crash();"}
+	s.mu.Unlock()
+
+	checkFatalf(t, "Kill() error: %v", processes()[0].Kill())
+
+	// The first Run may fail on the killed process before the monitor has
+	// restarted it
+	s.Run("1;")
+	if _, err := s.Run("1;"); err != ErrRestartLimit {
+		t.Errorf("expected ErrRestartLimit, got: %v", err)
+	}
+	if n := len(processes()); n != 3 {
+		t.Errorf("expected 3 processes, got %d", n)
+	}
+}
+
+func TestSupervisorSeed(t *testing.T) {
+	new, processes := poolProcesses(newTestProcess)
+	reports := make(chan *RestartReport, 1)
+	s := &Supervisor{New: new, OnRestart: func(r *RestartReport) { reports <- r }}
+	checkFatalf(t, "Start() error: %v", s.Start())
+	defer s.Close()
+
+	// No statements have been run before the restart
+	checkFatalf(t, "Kill() error: %v", processes()[0].Kill())
+	select {
+	case <-reports:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for restart")
+	}
+
+	ps := processes()
+	if len(ps) != 2 {
+		t.Fatalf("expected 2 processes, got %d", len(ps))
+	}
+	if o := ps[1].Options; o == nil || o.Seed == nil || *o.Seed != 1 {
+		t.Errorf("expected restarted process to have seed 1, got: %+v", o)
+	}
+}
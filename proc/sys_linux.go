@@ -0,0 +1,78 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sigCPULimit is the signal sent when the soft CPU time limit is exceeded.
+const sigCPULimit = syscall.SIGXCPU
+
+// setSysProcAttr configures p.cmd to run in its own process group (if
+// p.ProcessGroup is set).
+func (p *Process) setSysProcAttr() error {
+	if p.ProcessGroup {
+		p.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	return nil
+}
+
+// limitShell is the shell used to set the resource limits in p.Limits before
+// Magma is executed.
+const limitShell = "/bin/sh"
+
+// setLimits runs p.cmd using limitShell, which sets the resource limits in
+// p.Limits and then replaces itself with Magma, so that the limits apply
+// from the start to Magma and any children it creates.  The hard CPU time
+// limit is one second beyond the soft limit, so that processes which ignore
+// SIGXCPU are killed.
+func (p *Process) setLimits() {
+	l := p.Limits
+	if l == nil || p.cmd.Err != nil {
+		return
+	}
+
+	var script []string
+	if l.AddressSpace > 0 {
+		// ulimit -v is in KiB, and a limit of 0 would prevent Magma starting
+		script = append(script, fmt.Sprintf("ulimit -v %d", (l.AddressSpace+1023)/1024))
+	}
+	if l.CPUTime > 0 {
+		secs := uint64((l.CPUTime + time.Second - 1) / time.Second)
+		script = append(script, fmt.Sprintf("ulimit -t %d", secs+1), fmt.Sprintf("ulimit -S -t %d", secs))
+	}
+	if l.OpenFiles > 0 {
+		script = append(script, fmt.Sprintf("ulimit -n %d", l.OpenFiles))
+	}
+	script = append(script, `exec "$0" "$@"`)
+
+	args := []string{"sh", "-c", strings.Join(script, " && "), p.cmd.Path}
+	p.cmd.Args = append(args, p.cmd.Args[1:]...)
+	p.cmd.Path = limitShell
+}
+
+// sendSignal sends sig to the underlying Magma process, or to its process
+// group if sig is os.Kill and p.ProcessGroup is set.
+func (p *Process) sendSignal(sig os.Signal) error {
+	if p.ProcessGroup && sig == os.Kill {
+		return syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+// maxRSS returns the maximum resident set size (in bytes) of the process.
+func maxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return ru.Maxrss * 1024
+	}
+	return 0
+}
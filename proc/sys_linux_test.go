@@ -0,0 +1,203 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// limitsApplied returns the expected lines of /proc/<pid>/limits which are
+// not in the given file contents.
+func limitsApplied(b []byte, expected []string) []string {
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.Join(strings.Fields(l), " ")
+		for i, e := range expected {
+			if l == e {
+				expected = append(expected[:i], expected[i+1:]...)
+				break
+			}
+		}
+	}
+	return expected
+}
+
+func TestSetLimitsBeforeExec(t *testing.T) {
+	p := &Process{Limits: &Limits{AddressSpace: 1 << 30, CPUTime: 1500 * time.Millisecond, OpenFiles: 50}}
+	p.cmd = exec.Command("cat", "/proc/self/limits")
+	p.setLimits()
+
+	// The limits are in place when the command starts
+	b, err := p.cmd.Output()
+	checkFatalf(t, "Output() error: %v", err)
+	missing := limitsApplied(b, []string{
+		fmt.Sprintf("Max address space %d %d bytes", uint64(1<<30), uint64(1<<30)),
+		"Max cpu time 2 3 seconds",
+		"Max open files 50 50 files",
+	})
+	if len(missing) > 0 {
+		t.Errorf("limits not applied: %v\n%s", missing, b)
+	}
+}
+
+func TestSetLimitsAddressSpaceRounded(t *testing.T) {
+	p := &Process{Limits: &Limits{AddressSpace: 1}}
+	p.cmd = exec.Command("true")
+	p.setLimits()
+
+	if script := p.cmd.Args[2]; !strings.HasPrefix(script, "ulimit -v 1 ") {
+		t.Errorf("expected address space limit of 1 KiB, got script %q", script)
+	}
+}
+
+func TestExitMemoryLimit(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "kill -SEGV $$")
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("expected command to be ended by a signal")
+	}
+
+	e := newExit(cmd.ProcessState, &Limits{AddressSpace: 1 << 30})
+	if e.Reason != ExitMemoryLimit || e.Signal != syscall.SIGSEGV {
+		t.Errorf("expected exit reason %v by %v, got %+v", ExitMemoryLimit, syscall.SIGSEGV, e)
+	}
+	if e := newExit(cmd.ProcessState, nil); e.Reason != ExitSignal {
+		t.Errorf("expected exit reason %v without limits, got %+v", ExitSignal, e)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	p := newFakeProcess()
+	p.Limits = &Limits{AddressSpace: 64 << 30, CPUTime: 90 * time.Second, OpenFiles: 100}
+	p.ProcessGroup = true
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	// Magma has replaced the shell which set the limits once it is ready
+	_, err = p.Run("1;")
+	checkFatalf(t, "Run() error: %v", err)
+
+	pid, err := p.Getpid()
+	checkFatalf(t, "Getpid() error: %v", err)
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/limits", pid))
+	checkFatalf(t, "ReadFile() error: %v", err)
+
+	expected := []string{
+		fmt.Sprintf("Max address space %d %d bytes", uint64(64<<30), uint64(64<<30)),
+		"Max cpu time 90 91 seconds",
+		"Max open files 100 100 files",
+	}
+	if expected = limitsApplied(b, expected); len(expected) > 0 {
+		t.Errorf("limits not applied: %v\n%s", expected, b)
+	}
+
+	pgid, err := syscall.Getpgid(pid)
+	checkErrorf(t, "Getpgid() error: %v", err)
+	if pgid != pid {
+		t.Errorf("expected process group %d, got %d", pid, pgid)
+	}
+
+	testQuitAndWait(p, t)
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+	if e := p.Exit(); e == nil || e.Reason != ExitNormal {
+		t.Errorf("expected Exit() reason %v, got %+v", ExitNormal, e)
+	}
+}
+
+func TestKillProcessGroup(t *testing.T) {
+	p := newFakeProcess()
+	p.ProcessGroup = true
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	o, err := p.Execute("System(\"sleep 60\");")
+	checkFatalf(t, "Execute() error: %v", err)
+
+	var child int
+	out := o.Output()
+	for x := range out {
+		if l, ok := x.(*Line); ok {
+			child, _ = strconv.Atoi(l.Data)
+			break
+		}
+	}
+	if child == 0 {
+		t.Fatalf("expected pid of child process")
+	}
+
+	checkErrorf(t, "Kill() error: %v", p.Kill())
+	Discard(out)
+	err = p.Wait()
+
+	var ee *ExitError
+	if !errors.As(err, &ee) {
+		t.Fatalf("expected Wait() to return *ExitError, got %v", err)
+	}
+	if ee.Exit.Reason != ExitSignal || ee.Exit.Signal != syscall.SIGKILL {
+		t.Errorf("expected exit by %v, got %+v", syscall.SIGKILL, ee.Exit)
+	}
+	if p.Exit() != ee.Exit {
+		t.Errorf("expected Exit() to match ExitError")
+	}
+
+	// The child is killed, but may remain a zombie if nothing reaps it.
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", child))
+		if err != nil || strings.Contains(string(b), ") Z ") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("child process %d was not killed", child)
+}
+
+func TestCPULimit(t *testing.T) {
+	p := newFakeProcess()
+	p.Limits = &Limits{CPUTime: time.Second}
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	o, err := p.Execute("repeat i := 1; until false;")
+	checkFatalf(t, "Execute() error: %v", err)
+	Discard(o.Output())
+
+	done := make(chan error, 1)
+	go func() { done <- p.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(20 * time.Second):
+		p.Kill()
+		t.Fatalf("timed out waiting for CPU time limit")
+	}
+
+	var ee *ExitError
+	if !errors.As(err, &ee) {
+		t.Fatalf("expected Wait() to return *ExitError, got %v", err)
+	}
+	if ee.Exit.Reason != ExitCPULimit {
+		t.Errorf("expected exit reason %v, got %+v", ExitCPULimit, ee.Exit)
+	}
+	if ee.Exit.CPUTime < time.Second {
+		t.Errorf("expected CPU time of at least 1s, got %v", ee.Exit.CPUTime)
+	}
+	if ee.Exit.MaxRSS <= 0 {
+		t.Errorf("expected positive MaxRSS, got %d", ee.Exit.MaxRSS)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package proc
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// sigCPULimit is never delivered: CPU time limits are not supported.
+const sigCPULimit = syscall.Signal(-1)
+
+// setSysProcAttr returns an error as resource limits and process groups are
+// only supported on Linux.
+func (p *Process) setSysProcAttr() error {
+	if p.Limits != nil || p.ProcessGroup {
+		return errors.New("magma/proc: Limits and ProcessGroup are only supported on linux")
+	}
+	return nil
+}
+
+func (p *Process) setLimits() {}
+
+func (p *Process) sendSignal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func maxRSS(ps *os.ProcessState) int64 { return 0 }
@@ -4,12 +4,12 @@
 
 package proc
 
-// tag specifies a type of output, or status flag given by the Magma process.
-type tag string
+// Tag specifies a type of output, or status flag given by the Magma process.
+type Tag string
 
 // Line tags that are produced by Magma
 const (
-	TagOutput               tag = "OUT"    // Normal output
+	TagOutput               Tag = "OUT"    // Normal output
 	TagList                     = "LST"    // List output
 	TagSignature                = "SIG"    // Signature output
 	TagErrorSyntax              = "ENE"    // Input finished before statement was complete
@@ -28,7 +28,7 @@ const (
 
 // statusTag is a special tag which indicates a change of status of the underlying
 // Magma process
-type statusTag tag
+type statusTag Tag
 
 // Status tags that are produced by Magma
 const (
@@ -46,7 +46,7 @@ const (
 // data received directly from Magma (and thus have an associated output
 // tag).
 type Tagged interface {
-	Tag() tag
+	Tag() Tag
 }
 
 // IsError returns true if the given tag is part of error
@@ -61,7 +61,7 @@ func IsError(t Tagged) bool {
 }
 
 // Tag returns the underlying tag for the given tag!
-func (t tag) Tag() tag {
+func (t Tag) Tag() Tag {
 	return t
 }
 
@@ -71,8 +71,8 @@ type Status struct {
 }
 
 // Tag returns the statusTag associated with this Status instance
-func (s *Status) Tag() tag {
-	return tag(s.tag)
+func (s *Status) Tag() Tag {
+	return Tag(s.tag)
 }
 
 // Ready represents the ready state and gives more detailed status output
@@ -82,18 +82,24 @@ type Ready struct {
 }
 
 // Tag returns the statusTag associated with the Ready instance
-func (r *Ready) Tag() tag {
-	return tag(TagReady)
+func (r *Ready) Tag() Tag {
+	return Tag(TagReady)
 }
 
 // Line represents the standard data output, which contains an indent level
 // and a continuation flag indicating if the output data should begin with
 // a new line.
 type Line struct {
-	tag
+	tag          Tag
 	Continuation bool   // Should this start a new line of output?
 	Indent       int    // Indentation level
 	Data         string // Captured output line (following tag line)
+	Truncated    bool   // Data was truncated (see Process.MaxLineLength)
+}
+
+// Tag returns the tag of the output line.
+func (l *Line) Tag() Tag {
+	return l.tag
 }
 
 // Position tag output, commonly precedes error messages/traceback, and gives
@@ -104,14 +110,35 @@ type Position struct {
 }
 
 // Tag returns the tag corresponding to Position
-func (p *Position) Tag() tag {
+func (p *Position) Tag() Tag {
 	return TagErrorHistoryPosition
 }
 
 // ReadRequest is used in interactions need for `read`/`readi` statements
 type ReadRequest struct {
-	tag
+	tag    Tag
 	Prompt string      // Tag and prompt to show to user
 	Output chan string // Channel to allow for pass-back
 	Err    chan error  // Error if not fullfilled
+
+	Retries  int   // Number of times the request has been retried
+	Rejected error // Why the previous answer was rejected (if Retries > 0)
+}
+
+// Tag returns the tag of the read request (RD_PR, RD_IN, RDI_PR or RDI_IN).
+func (r *ReadRequest) Tag() Tag {
+	return r.tag
+}
+
+// retry returns a new request which retries r after its answer was rejected
+// with err.
+func (r *ReadRequest) retry(err error) *ReadRequest {
+	return &ReadRequest{
+		tag:      r.tag,
+		Prompt:   r.Prompt,
+		Output:   make(chan string),
+		Err:      make(chan error),
+		Retries:  r.Retries + 1,
+		Rejected: err,
+	}
 }
@@ -6,7 +6,7 @@ package proc
 
 import "testing"
 
-func pos(t tag, s []tag) int {
+func pos(t Tag, s []Tag) int {
 	for p, v := range s {
 		if v == t {
 			return p
@@ -16,7 +16,7 @@ func pos(t tag, s []tag) int {
 }
 
 func TestIsError(t *testing.T) {
-	var allOutputTags = [...]tag{
+	var allOutputTags = [...]Tag{
 		TagOutput,
 		TagList,
 		TagSignature,
@@ -34,7 +34,7 @@ func TestIsError(t *testing.T) {
 		TagReadIntError,
 	}
 
-	var errorTags = [...]tag{
+	var errorTags = [...]Tag{
 		TagErrorSyntax,
 		TagErrorInternal,
 		TagErrorUser,
@@ -0,0 +1,166 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcome describes how the execution of a command ended.
+type Outcome int
+
+// Outcomes of executing a command.  Each step in the escalation of an
+// interrupt has a corresponding Outcome.
+const (
+	OutcomeCompleted   Outcome = iota // Completed without being interrupted
+	OutcomeInterrupted                // Interrupted, the session survived
+	OutcomeQuit                       // Interrupted twice, making Magma quit
+	OutcomeKilled                     // The process was killed
+	OutcomeExited                     // The process exited before the command completed
+)
+
+// ErrExited is returned by Run when the process exits before the command
+// completes (see OutcomeExited).  Use Process.Wait and Process.Exit to find
+// out how the process ended.
+var ErrExited = errors.New("magma/proc: process exited before the command completed")
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeCompleted:
+		return "completed"
+	case OutcomeInterrupted:
+		return "interrupted"
+	case OutcomeQuit:
+		return "quit"
+	case OutcomeKilled:
+		return "killed"
+	case OutcomeExited:
+		return "exited"
+	}
+	return "unknown"
+}
+
+// SessionSurvived returns true if the Magma session is still usable after a
+// command with this outcome.
+func (o Outcome) SessionSurvived() bool {
+	return o == OutcomeCompleted || o == OutcomeInterrupted
+}
+
+// escalation records the outcome of an Output, and the reason for any
+// interrupt.
+type escalation struct {
+	mu      sync.Mutex
+	outcome Outcome
+	reason  error
+}
+
+func (e *escalation) set(o Outcome, reason error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.outcome = o
+	if reason != nil {
+		e.reason = reason
+	}
+}
+
+// exited records that the process exited before the output was complete,
+// unless it was already quit or killed by an escalation.
+func (e *escalation) exited() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.outcome.SessionSurvived() {
+		e.outcome = OutcomeExited
+	}
+}
+
+// Outcome returns how the execution of the command ended, and the reason it
+// was interrupted (context.DeadlineExceeded if Process.Timeout elapsed, or
+// the context error from ExecuteContext), or nil if it was not.  The value is
+// final once all the output has been read.
+func (o Output) Outcome() (Outcome, error) {
+	o.esc.mu.Lock()
+	defer o.esc.mu.Unlock()
+	return o.esc.outcome, o.esc.reason
+}
+
+// watch escalates an interrupt of the execution which produces o if ctx is
+// done, or the timeout (if non-zero) elapses, before the output is complete.
+func (p *Process) watch(ctx context.Context, o *Output, timeout time.Duration) {
+	var tc <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		tc = t.C
+	}
+
+	select {
+	case <-ctx.Done():
+		p.escalate(o, ctx.Err())
+	case <-tc:
+		p.escalate(o, context.DeadlineExceeded)
+	case <-o.done:
+	case <-p.exited:
+	}
+}
+
+// escalate interrupts the running statement, and then (at intervals of
+// p.InterruptGrace) sends a second interrupt and finally kills the process,
+// stopping as soon as Magma responds or the output o is complete.
+func (p *Process) escalate(o *Output, reason error) {
+	grace := p.InterruptGrace
+	if grace == 0 {
+		grace = DefaultInterruptGrace
+	}
+	wait := func(ch <-chan struct{}) bool {
+		t := time.NewTimer(grace)
+		defer t.Stop()
+		select {
+		case <-ch:
+		case <-o.done:
+		case <-p.exited:
+		case <-t.C:
+			return false
+		}
+		return true
+	}
+
+	o.esc.set(OutcomeInterrupted, reason)
+	ich, err := p.InterruptExecution()
+	if err != nil {
+		// An interrupt may already be pending, in which case we still
+		// wait for the output to complete before escalating.
+		ich = nil
+	}
+	if wait(ich) {
+		return
+	}
+
+	o.esc.set(OutcomeQuit, nil)
+	if err := p.signal(os.Interrupt); err != nil {
+		return
+	}
+	if wait(nil) {
+		return
+	}
+
+	o.esc.set(OutcomeKilled, nil)
+	p.Kill()
+}
+
+// signal sends the signal sig to the underlying Magma process.
+func (p *Process) signal(sig os.Signal) error {
+	err := p.checkRunning()
+	if err != nil {
+		return err
+	}
+	if p.transcript != nil {
+		p.transcript.record(transcriptSignal, []byte(sig.String()))
+	}
+	return p.sendSignal(sig)
+}
@@ -0,0 +1,84 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dhowden/magma/proc/magmatest"
+)
+
+// runWithTimeout runs cmd on p with a short timeout, and returns the outcome.
+func runWithTimeout(t *testing.T, p *Process, cmd string) (Outcome, error) {
+	p.Timeout = 100 * time.Millisecond
+	p.InterruptGrace = 500 * time.Millisecond
+
+	so, err := p.Start()
+	checkFatalf(t, "Start() error: %v", err)
+	Discard(so.Output())
+
+	done := make(chan *Output, 1)
+	go func() {
+		o, err := p.Execute(cmd)
+		checkFatalf(t, "Execute() error: %v", err)
+		Discard(o.Output())
+		<-o.done
+		done <- o
+	}()
+
+	select {
+	case o := <-done:
+		return o.Outcome()
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for output to complete")
+	}
+	return 0, nil
+}
+
+func TestTimeoutInterrupted(t *testing.T) {
+	p := newTestProcess()
+	outcome, reason := runWithTimeout(t, p, "while i lt 1 do print i; end while;")
+	if outcome != OutcomeInterrupted || reason != context.DeadlineExceeded {
+		t.Errorf("expected outcome %v (%v), got %v (%v)", OutcomeInterrupted, context.DeadlineExceeded, outcome, reason)
+	}
+	if !outcome.SessionSurvived() {
+		t.Errorf("expected session to survive")
+	}
+
+	p.Timeout = 0
+	res, err := p.Run("1;")
+	checkFatalf(t, "Run() error: %v", err)
+	if res.Outcome != OutcomeCompleted {
+		t.Errorf("expected outcome %v, got %v", OutcomeCompleted, res.Outcome)
+	}
+	testQuitAndWait(p, t)
+}
+
+func TestTimeoutQuit(t *testing.T) {
+	cmd, env := magmatest.Command(fakeName)
+	p := &Process{Command: cmd, Env: env}
+	outcome, _ := runWithTimeout(t, p, "while true do end while;")
+	if outcome != OutcomeQuit {
+		t.Errorf("expected outcome %v, got %v", OutcomeQuit, outcome)
+	}
+	if outcome.SessionSurvived() {
+		t.Errorf("expected session not to survive")
+	}
+	checkErrorf(t, "Wait() error: %v", p.Wait())
+}
+
+func TestTimeoutKilled(t *testing.T) {
+	cmd, env := magmatest.Command(fakeHungName)
+	p := &Process{Command: cmd, Env: env}
+	outcome, _ := runWithTimeout(t, p, "while true do end while;")
+	if outcome != OutcomeKilled {
+		t.Errorf("expected outcome %v, got %v", OutcomeKilled, outcome)
+	}
+	if err := p.Wait(); err == nil {
+		t.Errorf("expected Wait() error after process was killed")
+	}
+}
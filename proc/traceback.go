@@ -0,0 +1,192 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/magma/proc/internal/lineparse"
+)
+
+// ParamValue represents pairs of function parameter names and their values
+type ParamValue struct {
+	Name, Value string
+}
+
+// Location represents a location in a source file, or a C glue function
+type Location struct {
+	File string
+	Row  int
+	Glue string
+}
+
+// Traceback level information
+type Traceback struct {
+	Index    int          // index in the back trace (-1 if not set)
+	Current  bool         // the current frame
+	Name     string       // function name
+	Params   []ParamValue // parameters
+	Location Location
+}
+
+// Index value for unset state
+const NoIndex int = -1
+
+// ParseTraceback parses the traceback levels from the data of TB lines.  Any
+// levels parsed before an error are returned along with the error.
+func ParseTraceback(lines []string) ([]*Traceback, error) {
+	p := &tracebackParser{Consumer: lineparse.NewSliceConsumer(lines)}
+	state := parseTraceback
+	for state != nil {
+		state = state(p)
+	}
+	return p.out, p.err
+}
+
+type tracebackParserStateFn func(*tracebackParser) tracebackParserStateFn
+
+// tracebackParser is the container associated with the traceback parser
+type tracebackParser struct {
+	*lineparse.Consumer
+	err     error        // Parse error (if any)
+	current *Traceback   // Current Traceback object (in construction)
+	out     []*Traceback // Completed tracebacks
+}
+
+// Discard output until a traceback level statement, and parse it
+func parseTraceback(p *tracebackParser) tracebackParserStateFn {
+	for p.FetchNextLine() {
+		if name := strings.TrimSuffix(p.Line, "("); len(name) < len(p.Line) {
+			p.current = &Traceback{Name: name, Index: NoIndex}
+			if levelName := strings.TrimPrefix(name, "#"); len(levelName) < len(name) {
+				levelNameFields := strings.Fields(levelName)
+				if len(levelNameFields) != 2 {
+					p.err = fmt.Errorf("expected split into 2, got %v", levelNameFields)
+					return nil
+				}
+				index, err := strconv.Atoi(levelNameFields[0])
+				if err != nil {
+					p.err = err
+					return nil
+				}
+				p.current.Index = index
+				nameWithoutMarker := strings.TrimPrefix(levelNameFields[1], "*")
+				p.current.Name = nameWithoutMarker
+				if len(nameWithoutMarker) < len(levelNameFields[1]) {
+					p.current.Current = true
+				}
+			}
+			p.ConsumeLine()
+			return parseTracebackParam
+		}
+		p.ConsumeLine()
+	}
+	return nil
+}
+
+// Parse the parmeters
+func parseTracebackParam(p *tracebackParser) tracebackParserStateFn {
+	for p.FetchNextLine() {
+		// Got to the end of the params
+		if strings.HasPrefix(p.Line, ")") {
+			return parseTracebackLocation
+		}
+		fields := strings.SplitN(p.Line, ": ", 2)
+		if len(fields) != 2 {
+			p.err = fmt.Errorf("expected a split of 2, got %v", fields)
+			return nil
+		}
+		// Remove the trailing , if there is one...
+		fields[1] = strings.TrimSuffix(fields[1], ",")
+		p.current.Params = append(p.current.Params, ParamValue{fields[0], fields[1]})
+		p.ConsumeLine()
+	}
+	p.err = errors.New("unexpected end of traceback parameters")
+	return nil
+}
+
+func parseTracebackLocation(p *tracebackParser) tracebackParserStateFn {
+	locationLine := strings.SplitN(p.Line, " at ", 2)
+	p.ConsumeLine()
+	if len(locationLine) == 2 {
+		index := strings.LastIndex(locationLine[1], ":")
+		if index == -1 {
+			p.err = fmt.Errorf("expected ':', but did not find one in '%v'", locationLine[1])
+			return nil
+		}
+		line, err := strconv.Atoi(locationLine[1][index+1:])
+		if err != nil {
+			p.err = err
+			return nil
+		}
+		p.current.Location = Location{File: locationLine[1][:index], Row: line}
+	}
+	p.out = append(p.out, p.current)
+	return parseTraceback
+}
+
+// WriteTo writes the raw output equivalent of the ParamValue struct
+// to the given io.Writer.
+func (pv *ParamValue) WriteTo(w io.Writer) (n int64, err error) {
+	c, err := w.Write([]byte("\n" + "    " + pv.Name + " : " + pv.Value))
+	n = int64(c)
+	return
+}
+
+// WriteTo writes the raw output equivalent of the Location struct
+// to the given io.Writer.
+func (sp *Location) WriteTo(w io.Writer) (n int64, err error) {
+	output := ""
+	if sp.Glue != "" {
+		output += "defined in glue: " + sp.Glue
+	} else if sp.File != "" {
+		output += "defined in file: " + sp.File + ", line " + strconv.Itoa(sp.Row)
+	}
+	c, err := w.Write([]byte(output + "\n"))
+	n = int64(c)
+	return
+}
+
+// WriteTo writes the raw output equivalent of the Traceback struct
+// to the given io.Writer.
+func (tb *Traceback) WriteTo(w io.Writer) (n int64, err error) {
+	output := ""
+	if tb.Index != NoIndex {
+		output += strconv.Itoa(tb.Index)
+	}
+	output += " "
+	if tb.Current {
+		output += "*"
+	}
+	output += tb.Name + "("
+
+	c, err := w.Write([]byte(output))
+	n += int64(c)
+	if err != nil {
+		return
+	}
+
+	var c64 int64
+	for _, p := range tb.Params {
+		c64, err = p.WriteTo(w)
+		n += c64
+		if err != nil {
+			return
+		}
+	}
+
+	c, err = w.Write([]byte("\n), "))
+	n += int64(c)
+	if err != nil {
+		return
+	}
+	tb.Location.WriteTo(w)
+
+	return
+}
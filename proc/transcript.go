@@ -0,0 +1,214 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Transcripts are line-based: each line is a record of bytes written to Magma
+// stdin ("> "), a line read from Magma stdout ("< "), a truncated line read
+// from Magma stdout ("~ ", see Process.MaxLineLength), or a signal sent to
+// the process ("! "), followed by the data as a Go quoted string.
+// Blank lines and lines beginning with "#" are ignored when replaying.
+const (
+	transcriptInput     = "> "
+	transcriptOutput    = "< "
+	transcriptTruncated = "~ "
+	transcriptSignal    = "! "
+)
+
+// transcript records the communication with a Magma process.
+type transcript struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error // First error writing the transcript
+}
+
+func newTranscript(w io.Writer) *transcript {
+	return &transcript{w: w}
+}
+
+func (t *transcript) record(kind string, b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		_, t.err = fmt.Fprintf(t.w, "%s%s\n", kind, strconv.Quote(string(b)))
+	}
+}
+
+// transcriptWriter records all writes to w as input.
+type transcriptWriter struct {
+	w io.Writer
+	t *transcript
+}
+
+func (tw *transcriptWriter) Write(b []byte) (int, error) {
+	tw.t.record(transcriptInput, b)
+	return tw.w.Write(b)
+}
+
+// transcriptRecord is a single decoded line of a transcript.
+type transcriptRecord struct {
+	kind string
+	data []byte
+}
+
+func readTranscript(r io.Reader) ([]transcriptRecord, error) {
+	var recs []transcriptRecord
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<30)
+	for n := 1; s.Scan(); n++ {
+		l := s.Text()
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if len(l) < 2 {
+			return nil, fmt.Errorf("magma/proc: transcript line %d: invalid record", n)
+		}
+		kind := l[:2]
+		switch kind {
+		case transcriptInput, transcriptOutput, transcriptTruncated, transcriptSignal:
+		default:
+			return nil, fmt.Errorf("magma/proc: transcript line %d: unknown record type %q", n, kind)
+		}
+		data, err := strconv.Unquote(l[2:])
+		if err != nil {
+			return nil, fmt.Errorf("magma/proc: transcript line %d: %v", n, err)
+		}
+		recs = append(recs, transcriptRecord{kind: kind, data: []byte(data)})
+	}
+	return recs, s.Err()
+}
+
+// ReplayF defines a function prototype used to receive each Output produced
+// when replaying a transcript using Replay.
+type ReplayF func(o *Output) error
+
+// Replay reads a transcript (see Process.Transcript) and feeds the recorded
+// Magma output back through the output parser as if Magma were running.
+// The startup Output, and then the Output for each command in the transcript,
+// is passed to f in turn.  Any responses not consumed by f are discarded once
+// f returns (but if f uses o.Output() then it must read it to completion).
+//
+// Answers to a ReadRequest are ignored (the recorded output already reflects
+// the original answer) but f must still respond to any ReadRequest it receives.
+// Replay returns the first error returned by f or encountered when parsing
+// the transcript.
+func Replay(r io.Reader, f ReplayF) error {
+	recs, err := readTranscript(r)
+	if err != nil {
+		return err
+	}
+
+	p := &Process{replay: true}
+	p.initSession(io.Discard)
+
+	ch := make(chan rawLine)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var parseErr, feedErr error
+
+	go func() {
+		parseErr = p.parseStdoutLines(ch)
+		close(stop)
+		close(p.exited)
+		close(p.response)
+	}()
+
+	go func() {
+		defer close(done)
+		defer close(ch)
+		feedErr = p.feedTranscript(recs, ch, stop)
+	}()
+
+	for o := range p.response {
+		if f != nil {
+			if err = f(o); err != nil {
+				f = nil
+			}
+		}
+		drainOutput(o)
+	}
+	<-done
+
+	if err != nil {
+		return err
+	}
+	if feedErr != nil {
+		return feedErr
+	}
+	if parseErr == errNoTagLine {
+		// The transcript ended whilst Magma was waiting for input
+		return nil
+	}
+	return parseErr
+}
+
+// feedTranscript passes recorded output lines to the parser, and passes an
+// Output to the parser for each recorded command.
+func (p *Process) feedTranscript(recs []transcriptRecord, ch chan<- rawLine, stop <-chan struct{}) error {
+	var input []byte
+	running := false
+
+	for _, rec := range recs {
+		switch rec.kind {
+		case transcriptInput:
+			if running {
+				// Answer to a read prompt
+				continue
+			}
+			input = append(input, rec.data...)
+			if i := bytes.IndexByte(input, runCommandChar); i >= 0 {
+				var rch chan *Output
+				select {
+				case rch = <-p.ready:
+				case <-stop:
+					return nil
+				}
+				sent := string(input[:i])
+				rch <- p.newOutput(string(p.Encoding.decode(input[:i])), sent)
+				input = input[i+1:]
+				running = true
+			}
+
+		case transcriptOutput, transcriptTruncated:
+			if tagName, _, _ := parseTagLine(rec.data); tagName != nil {
+				switch statusTag(tagName) {
+				case TagReady, TagQuit:
+					running = false
+				}
+			}
+			select {
+			case ch <- rawLine{data: rec.data, truncated: rec.kind == transcriptTruncated}:
+			case <-stop:
+				return nil
+			}
+		}
+	}
+	if running {
+		return errors.New("magma/proc: transcript ended whilst a command was running")
+	}
+	return nil
+}
+
+// drainOutput reads all the remaining output from o, answering any read requests
+// with empty input.
+func drainOutput(o *Output) {
+	for r := range o.Responses() {
+		for x := range r.Output() {
+			if x, ok := x.(*ReadRequest); ok {
+				x.Output <- ""
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2014, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collectLines returns the data from all the *Line output in o, answering
+// any read requests with empty input.
+func collectLines(o *Output) []string {
+	var lines []string
+	for x := range o.Output() {
+		switch x := x.(type) {
+		case *Line:
+			lines = append(lines, x.Data)
+		case *ReadRequest:
+			x.Output <- ""
+		}
+	}
+	return lines
+}
+
+func TestTranscriptRecordAndReplay(t *testing.T) {
+	var inputs = []string{"a := 1; print a;", `print "Hello";`, "1 mod 0;"}
+
+	var buf bytes.Buffer
+	var recorded [][]string
+
+	p := newTestProcess()
+	p.Transcript = &buf
+	test := func(p *Process, t errorfer, st <-chan Tagged) {
+		go emptyTaggedChToLogPrintf("Status tag received: %v", st)
+		for _, in := range inputs {
+			o, err := p.Execute(in)
+			checkFatalf(t, "Execute() error: %v", err)
+			recorded = append(recorded, collectLines(o))
+		}
+		testQuitAndWait(p, t)
+	}
+	err := runCustomProcess(p, test, t)
+	checkFatalf(t, "unexpected error: %v", err)
+
+	var replayed [][]string
+	var commands []string
+	first := true
+	err = Replay(&buf, func(o *Output) error {
+		if first {
+			first = false
+			return nil
+		}
+		commands = append(commands, o.Command())
+		replayed = append(replayed, collectLines(o))
+		return nil
+	})
+	checkFatalf(t, "Replay() error: %v", err)
+
+	// The final command is the quit; sent by testQuitAndWait
+	if len(commands) != len(inputs)+1 || commands[len(inputs)] != "quit;" {
+		t.Fatalf("expected commands %v followed by quit;, got %v", inputs, commands)
+	}
+	for i := range inputs {
+		if commands[i] != inputs[i] {
+			t.Errorf("expected replayed command %q, got %q", inputs[i], commands[i])
+		}
+		if strings.Join(replayed[i], "\n") != strings.Join(recorded[i], "\n") {
+			t.Errorf("expected replayed output %q, got %q", recorded[i], replayed[i])
+		}
+	}
+}
+
+// replayLines replays the transcript tr, returning the lines of output from
+// each Output.
+func replayLines(t *testing.T, tr string) [][]string {
+	var outputs [][]string
+	done := make(chan error, 1)
+	go func() {
+		done <- Replay(strings.NewReader(tr), func(o *Output) error {
+			outputs = append(outputs, collectLines(o))
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		checkFatalf(t, "Replay() error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Replay() timed out")
+	}
+	return outputs
+}
+
+func TestReplayReadStatement(t *testing.T) {
+	const tr = `# read statement answered with "x"
+< "\x81RDY 0 0 0 0 0"
+> "read x;"
+> "\x04"
+< "\x81IR"
+< "\x81RUN 1 0 0 0 0 7"
+< "\x81RD_PR 0\x81"
+< "\x81RD_IN"
+> "x\n"
+< "\x81RDY 0 0 0 0 0"
+> "x;"
+> "\x04"
+< "\x81IR"
+< "\x81RUN 1 0 0 0 0 2"
+< "\x81OUT 0\x81x"
+< "\x81RDY 0 0 0 0 0"
+`
+	outputs := replayLines(t, tr)
+	if len(outputs) != 3 || len(outputs[2]) != 1 || outputs[2][0] != "x" {
+		t.Errorf("expected output x from final command, got: %v", outputs)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	tests := []string{
+		"? \"\\x81RDY 0 0 0 0 0\"\n",
+		"< \\x81RDY 0 0 0 0 0\n",
+		"< \"\\x81RDY 0 0 0 0 0\"\n> \"1;\\x04\"\n< \"\\x81IR\"\n",
+	}
+
+	for _, tr := range tests {
+		err := Replay(strings.NewReader(tr), nil)
+		if err == nil {
+			t.Errorf("expected error from Replay() for transcript: %q", tr)
+		}
+	}
+}
+
+func TestReplayReadIntStatement(t *testing.T) {
+	const tr = `# readi statement answered with "3"
+< "\x81RDY 0 0 0 0 0"
+> "readi x; x;"
+> "\x04"
+< "\x81IR"
+< "\x81RUN 1 0 0 0 0 8"
+< "\x81RDI_PR 0\x81"
+< "\x81RDI_IN"
+> "3\n"
+< "\x81RUN 1 0 0 9 0 11"
+< "\x81OUT 0\x813"
+< "\x81RDY 0 0 0 0 0"
+`
+	var requests int
+	var outcome Outcome
+	err := Replay(strings.NewReader(tr), func(o *Output) error {
+		for x := range o.Output() {
+			if x, ok := x.(*ReadRequest); ok {
+				requests++
+				x.Output <- ""
+			}
+		}
+		outcome, _ = o.Outcome()
+		return nil
+	})
+	checkFatalf(t, "Replay() error: %v", err)
+	if requests != 1 || outcome != OutcomeCompleted {
+		t.Errorf("expected 1 read request and outcome %v, got %d and %v", OutcomeCompleted, requests, outcome)
+	}
+}
+
+func TestReplayDebugger(t *testing.T) {
+	const tr = `# statement stopped in the debugger
+< "\x81RDY 0 0 0 0 0"
+> "f(3);"
+> "\x04"
+< "\x81IR"
+< "\x81RUN 1 0 0 0 0 5"
+< "\x81OUT 0\x81start"
+< "\x81DRDY"
+> "continue\x04"
+< "\x81IR"
+< "\x81OUT 0\x81done"
+< "\x81RDY 0 0 0 0 0"
+`
+	outputs := replayLines(t, tr)
+	if len(outputs) != 2 || strings.Join(outputs[1], ",") != "start,done" {
+		t.Errorf("expected output start,done, got: %v", outputs)
+	}
+}
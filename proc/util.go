@@ -7,11 +7,20 @@ package proc
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 var nl = []byte{'\n'}
 var indent = []byte("    ")
 
+// magmaEscaper escapes the characters which are special in Magma strings.
+var magmaEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+
+// Quote returns s as a double-quoted Magma string literal.
+func Quote(s string) string {
+	return `"` + magmaEscaper.Replace(s) + `"`
+}
+
 // WriteTo writes the raw output equivalent of the Line object
 // to the given io.Writer.
 // NB: if o.Continuation then a newline preceeds the output